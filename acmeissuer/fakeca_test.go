@@ -0,0 +1,374 @@
+package acmeissuer_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// fakeCA is an in-process ACME (RFC 8555) CA issuing for any name.
+// Challenges are validated as soon as they are accepted, without contacting the solver,
+// and request signatures are not verified.
+type fakeCA struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+	nonces int
+
+	mu     sync.Mutex
+	orders map[string]*fakeOrder
+	// failValidations is how many upcoming validations fail, invalidating their order
+	failValidations int
+	// processing is how many fetches of a finalized order answer processing before it becomes valid,
+	// negative to never issue
+	processing int
+}
+
+type fakeOrder struct {
+	id         string
+	domain     string
+	status     string
+	authz      string
+	processing int
+	der        []byte
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	ca := &fakeCA{t: t, key: key, cert: cert, orders: make(map[string]*fakeOrder)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dir", ca.directory)
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /account", ca.account)
+	mux.HandleFunc("POST /order", ca.newOrder)
+	mux.HandleFunc("POST /order/{id}", ca.getOrder)
+	mux.HandleFunc("POST /authz/{id}", ca.getAuthz)
+	mux.HandleFunc("POST /chal/{id}", ca.accept)
+	mux.HandleFunc("POST /finalize/{id}", ca.finalize)
+	mux.HandleFunc("POST /cert/{id}", ca.getCert)
+	ca.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ca.mu.Lock()
+		ca.nonces++
+		nonce := strconv.Itoa(ca.nonces)
+		ca.mu.Unlock()
+		w.Header().Set("Replay-Nonce", nonce)
+		w.Header().Set("Cache-Control", "no-store")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ca.srv.Close)
+	return ca
+}
+
+// DirectoryURL returns the ACME directory URL of the CA.
+func (ca *fakeCA) DirectoryURL() string {
+	return ca.srv.URL + "/dir"
+}
+
+// NewOrders returns the number of orders created.
+func (ca *fakeCA) NewOrders() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return len(ca.orders)
+}
+
+// FailValidations makes the next n validations fail.
+func (ca *fakeCA) FailValidations(n int) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.failValidations = n
+}
+
+// SetProcessing sets how many fetches of finalized orders answer processing before they become valid,
+// including orders already processing. A negative n never issues.
+func (ca *fakeCA) SetProcessing(n int) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.processing = n
+	for _, o := range ca.orders {
+		if o.status == acme.StatusProcessing {
+			o.processing = n
+		}
+	}
+}
+
+func (ca *fakeCA) directory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   ca.srv.URL + "/nonce",
+		"newAccount": ca.srv.URL + "/account",
+		"newOrder":   ca.srv.URL + "/order",
+		"revokeCert": ca.srv.URL + "/revoke",
+		"keyChange":  ca.srv.URL + "/key-change",
+	})
+}
+
+func (ca *fakeCA) account(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Location", ca.srv.URL+"/account/1")
+	writeJSON(w, http.StatusCreated, map[string]any{"status": acme.StatusValid})
+}
+
+func (ca *fakeCA) newOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifiers []struct {
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}
+	if err := decodePayload(r, &req); err != nil || len(req.Identifiers) != 1 {
+		writeProblem(w, http.StatusBadRequest, "malformed", "expected a single identifier")
+		return
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	id := strconv.Itoa(len(ca.orders) + 1)
+	o := &fakeOrder{id: id, domain: req.Identifiers[0].Value, status: acme.StatusPending, authz: acme.StatusPending}
+	ca.orders[id] = o
+	w.Header().Set("Location", ca.orderURL(o))
+	writeJSON(w, http.StatusCreated, ca.orderJSON(o))
+}
+
+func (ca *fakeCA) getOrder(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	o, ok := ca.orders[r.PathValue("id")]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	body := ca.orderJSON(o)
+	if o.status == acme.StatusProcessing && o.processing > 0 {
+		o.processing--
+		if o.processing == 0 {
+			o.status = acme.StatusValid
+		}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (ca *fakeCA) getAuthz(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	o, ok := ca.orders[r.PathValue("id")]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+	writeJSON(w, http.StatusOK, ca.authzJSON(o))
+}
+
+func (ca *fakeCA) accept(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	o, ok := ca.orders[r.PathValue("id")]
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+	if ca.failValidations > 0 {
+		ca.failValidations--
+		o.authz, o.status = acme.StatusInvalid, acme.StatusInvalid
+	} else {
+		o.authz, o.status = acme.StatusValid, acme.StatusReady
+	}
+	writeJSON(w, http.StatusOK, ca.authzJSON(o)["challenges"].([]any)[0])
+}
+
+func (ca *fakeCA) finalize(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := decodePayload(r, &req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	o, ok := ca.orders[r.PathValue("id")]
+	if !ok || o.status != acme.StatusReady {
+		writeProblem(w, http.StatusForbidden, "orderNotReady", "order is not ready")
+		return
+	}
+	der, err := ca.issue(o.domain, req.CSR)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	o.der = der
+	o.status = acme.StatusValid
+	if ca.processing != 0 {
+		o.status = acme.StatusProcessing
+		o.processing = ca.processing
+	}
+	w.Header().Set("Location", ca.orderURL(o))
+	writeJSON(w, http.StatusOK, ca.orderJSON(o))
+}
+
+func (ca *fakeCA) getCert(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	o, ok := ca.orders[r.PathValue("id")]
+	if !ok || o.status != acme.StatusValid {
+		writeProblem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: o.der})
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// issue signs a certificate for domain with the key of the base64url encoded csr
+func (ca *fakeCA) issue(domain, csr string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(csr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.CheckSignature(); err != nil {
+		return nil, err
+	}
+	if len(req.DNSNames) != 1 || req.DNSNames[0] != domain {
+		return nil, errors.New("certificate request does not match the order")
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     req.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return x509.CreateCertificate(rand.Reader, tmpl, ca.cert, req.PublicKey, ca.key)
+}
+
+func (ca *fakeCA) orderURL(o *fakeOrder) string {
+	return ca.srv.URL + "/order/" + o.id
+}
+
+func (ca *fakeCA) orderJSON(o *fakeOrder) map[string]any {
+	body := map[string]any{
+		"status":         o.status,
+		"identifiers":    []any{map[string]string{"type": "dns", "value": o.domain}},
+		"authorizations": []string{ca.srv.URL + "/authz/" + o.id},
+		"finalize":       ca.srv.URL + "/finalize/" + o.id,
+	}
+	if o.status == acme.StatusValid {
+		body["certificate"] = ca.srv.URL + "/cert/" + o.id
+	}
+	if o.status == acme.StatusInvalid {
+		body["error"] = map[string]any{"type": "urn:ietf:params:acme:error:unauthorized", "detail": "validation failed"}
+	}
+	return body
+}
+
+func (ca *fakeCA) authzJSON(o *fakeOrder) map[string]any {
+	return map[string]any{
+		"status":     o.authz,
+		"identifier": map[string]string{"type": "dns", "value": o.domain},
+		"challenges": []any{map[string]any{
+			"type":   "http-01",
+			"url":    ca.srv.URL + "/chal/" + o.id,
+			"token":  "token" + o.id,
+			"status": o.authz,
+		}},
+	}
+}
+
+// decodePayload decodes the payload of the JWS request body into v
+func decodePayload(r *http.Request, v any) error {
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeProblem(w http.ResponseWriter, status int, problem, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":   "urn:ietf:params:acme:error:" + problem,
+		"detail": detail,
+	})
+}
+
+// fakeSolver solves the http-01 challenges of fakeCA, which validates without contacting it.
+// The first failPresent calls to Present fail.
+type fakeSolver struct {
+	mu          sync.Mutex
+	failPresent int
+}
+
+func (s *fakeSolver) ChallengeType() string {
+	return "http-01"
+}
+
+func (s *fakeSolver) Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failPresent > 0 {
+		s.failPresent--
+		return errors.New("present failed")
+	}
+	return nil
+}
+
+func (s *fakeSolver) CleanUp(ctx context.Context, domain string, chal *acme.Challenge) error {
+	return nil
+}
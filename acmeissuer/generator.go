@@ -0,0 +1,407 @@
+package acmeissuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+)
+
+// accountKeyState is the state key of the PEM-encoded account private key
+const accountKeyState = "account.key"
+
+// Option configures a Generator.
+type Option func(*Config)
+
+// Config holds configuration for a Generator.
+type Config struct {
+	// DirectoryURL is the ACME directory of the CA, acme.LetsEncryptURL by default.
+	DirectoryURL string
+	// Contact holds the account contact URIs, such as "mailto:admin@example.com".
+	Contact []string
+	// AcceptTOS is called with the CA terms of service URL during registration and must return true to accept them.
+	// The terms are declined by default, which only works for already registered accounts or CAs without terms.
+	AcceptTOS func(tosURL string) bool
+	// HTTPClient is used to talk to the CA, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// State persists the account key and in-progress orders, in memory by default.
+	State StateStore
+	// Solvers answer challenges, in order of preference.
+	Solvers []Solver
+	// NewKey generates certificate private keys, ECDSA P-256 by default.
+	NewKey func() (crypto.Signer, error)
+}
+
+// WithDirectoryURL sets the ACME directory of the CA.
+func WithDirectoryURL(url string) Option {
+	return func(cfg *Config) {
+		cfg.DirectoryURL = url
+	}
+}
+
+// WithContact sets the account contact URIs.
+func WithContact(contact ...string) Option {
+	return func(cfg *Config) {
+		cfg.Contact = contact
+	}
+}
+
+// WithAcceptTOS accepts the terms of service of the CA on registration.
+func WithAcceptTOS() Option {
+	return func(cfg *Config) {
+		cfg.AcceptTOS = acme.AcceptTOS
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to talk to the CA.
+func WithHTTPClient(client *http.Client) Option {
+	return func(cfg *Config) {
+		cfg.HTTPClient = client
+	}
+}
+
+// WithStateStore sets where the account key and in-progress orders are persisted.
+func WithStateStore(state StateStore) Option {
+	return func(cfg *Config) {
+		cfg.State = state
+	}
+}
+
+// WithSolvers adds challenge solvers, in order of preference.
+func WithSolvers(solvers ...Solver) Option {
+	return func(cfg *Config) {
+		cfg.Solvers = append(cfg.Solvers, solvers...)
+	}
+}
+
+// WithKeyGenerator sets the function generating certificate private keys.
+func WithKeyGenerator(newKey func() (crypto.Signer, error)) Option {
+	return func(cfg *Config) {
+		cfg.NewKey = newKey
+	}
+}
+
+// Generator implements store.Generator by obtaining certificates from an ACME (RFC 8555) CA.
+// The account is registered on first use. Orders are persisted in the state store while in progress,
+// so an interrupted issuance is resumed instead of starting over.
+type Generator struct {
+	cfg *Config
+
+	mu     sync.Mutex
+	client *acme.Client
+}
+
+// New creates a new Generator. At least one solver is required.
+func New(opts ...Option) (*Generator, error) {
+	cfg := &Config{
+		DirectoryURL: acme.LetsEncryptURL,
+		AcceptTOS:    declineTOS,
+		NewKey:       newECDSAKey,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if len(cfg.Solvers) == 0 {
+		return nil, errors.New("no challenge solvers")
+	}
+	if cfg.State == nil {
+		cfg.State = NewMemoryStateStore()
+	}
+	return &Generator{cfg: cfg}, nil
+}
+
+// Generate obtains a certificate for serverName.
+func (g *Generator) Generate(serverName string) (*tls.Certificate, error) {
+	return g.GenerateContext(context.Background(), serverName)
+}
+
+// GenerateContext obtains a certificate for serverName, aborting when ctx is done.
+// The order is kept in the state store on failure so the next call resumes it.
+func (g *Generator) GenerateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
+	client, err := g.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o, order, err := g.order(ctx, client, serverName)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status == acme.StatusPending {
+		if err := g.authorize(ctx, client, serverName, order); err != nil {
+			return nil, g.discardInvalid(ctx, client, serverName, o, err)
+		}
+		if order, err = client.WaitOrder(ctx, o.URL); err != nil {
+			return nil, g.discardInvalid(ctx, client, serverName, o, err)
+		}
+	}
+	if order.Status == acme.StatusProcessing {
+		// finalized by an earlier attempt, wait for the CA to issue the certificate
+		if order, err = client.WaitOrder(ctx, o.URL); err != nil {
+			return nil, g.discardInvalid(ctx, client, serverName, o, err)
+		}
+	}
+
+	var der [][]byte
+	switch order.Status {
+	case acme.StatusReady:
+		csr, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate(serverName), o.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create certificate request: %w", err)
+		}
+		if der, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, csr, true); err != nil {
+			return nil, g.discardInvalid(ctx, client, serverName, o, err)
+		}
+	case acme.StatusValid:
+		if der, err = client.FetchCert(ctx, order.CertURL, true); err != nil {
+			return nil, fmt.Errorf("failed to fetch certificate for %s: %w", serverName, err)
+		}
+	default:
+		return nil, g.discardInvalid(ctx, client, serverName, o, fmt.Errorf("unexpected order status %q", order.Status))
+	}
+
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	if err := g.cfg.State.DeleteState(ctx, orderState(serverName)); err != nil {
+		return nil, fmt.Errorf("failed to delete order state: %w", err)
+	}
+	return &tls.Certificate{
+		Certificate: der,
+		PrivateKey:  o.key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// authorize solves the pending authorizations of order.
+func (g *Generator) authorize(ctx context.Context, client *acme.Client, serverName string, order *acme.Order) error {
+	for _, url := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, url)
+		if err != nil {
+			return fmt.Errorf("failed to get authorization: %w", err)
+		}
+		if authz.Status != acme.StatusPending {
+			continue
+		}
+		solver, chal := g.pickChallenge(authz)
+		if solver == nil {
+			return fmt.Errorf("no solver for the challenges offered for %s", serverName)
+		}
+		if err := solver.Present(ctx, client, authz.Identifier.Value, chal); err != nil {
+			return fmt.Errorf("failed to present %s challenge: %w", chal.Type, err)
+		}
+		_, err = client.Accept(ctx, chal)
+		if err == nil {
+			_, err = client.WaitAuthorization(ctx, authz.URI)
+		}
+		// clean up with a fresh context so a cancelled generation does not leave responses behind
+		cleanupErr := solver.CleanUp(context.WithoutCancel(ctx), authz.Identifier.Value, chal)
+		if err != nil {
+			return fmt.Errorf("failed to solve %s challenge: %w", chal.Type, err)
+		}
+		if cleanupErr != nil {
+			return fmt.Errorf("failed to clean up %s challenge: %w", chal.Type, cleanupErr)
+		}
+	}
+	return nil
+}
+
+func (g *Generator) pickChallenge(authz *acme.Authorization) (Solver, *acme.Challenge) {
+	for _, solver := range g.cfg.Solvers {
+		for _, chal := range authz.Challenges {
+			if chal.Type == solver.ChallengeType() {
+				return solver, chal
+			}
+		}
+	}
+	return nil, nil
+}
+
+// persistedOrder is an order in progress along with the key of its certificate request
+type persistedOrder struct {
+	URL    string `json:"url"`
+	KeyPEM string `json:"key"`
+
+	key crypto.Signer
+}
+
+// order resumes the persisted order for serverName or creates a new one.
+// Persisted orders that are gone or invalid are replaced by a new order.
+func (g *Generator) order(ctx context.Context, client *acme.Client, serverName string) (*persistedOrder, *acme.Order, error) {
+	if o, order := g.resumeOrder(ctx, client, serverName); o != nil {
+		return o, order, nil
+	}
+
+	key, err := g.cfg.NewKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+	ids := acme.DomainIDs(serverName)
+	if net.ParseIP(serverName) != nil {
+		ids = acme.IPIDs(serverName)
+	}
+	order, err := client.AuthorizeOrder(ctx, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create order for %s: %w", serverName, err)
+	}
+	keyPEM, err := marshalKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := &persistedOrder{URL: order.URI, KeyPEM: string(keyPEM), key: key}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := g.cfg.State.SetState(ctx, orderState(serverName), data); err != nil {
+		return nil, nil, fmt.Errorf("failed to store order state: %w", err)
+	}
+	return o, order, nil
+}
+
+// resumeOrder returns the persisted order for serverName if it can still be completed, nil otherwise.
+func (g *Generator) resumeOrder(ctx context.Context, client *acme.Client, serverName string) (*persistedOrder, *acme.Order) {
+	data, err := g.cfg.State.GetState(ctx, orderState(serverName))
+	if err != nil {
+		return nil, nil
+	}
+	var o persistedOrder
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, nil
+	}
+	if o.key, err = parseKey([]byte(o.KeyPEM)); err != nil {
+		return nil, nil
+	}
+	order, err := client.GetOrder(ctx, o.URL)
+	if err != nil || order.Status == acme.StatusInvalid {
+		return nil, nil
+	}
+	return &o, order
+}
+
+// discardInvalid deletes the persisted order if the CA reports it as invalid, so the next attempt starts over.
+// It returns err wrapped with the server name.
+func (g *Generator) discardInvalid(ctx context.Context, client *acme.Client, serverName string, o *persistedOrder, err error) error {
+	var orderErr *acme.OrderError
+	invalid := errors.As(err, &orderErr) && orderErr.Status == acme.StatusInvalid
+	if !invalid {
+		var authzErr *acme.AuthorizationError
+		invalid = errors.As(err, &authzErr)
+	}
+	if !invalid {
+		if order, getErr := client.GetOrder(context.WithoutCancel(ctx), o.URL); getErr == nil {
+			invalid = order.Status == acme.StatusInvalid
+		}
+	}
+	if invalid {
+		_ = g.cfg.State.DeleteState(context.WithoutCancel(ctx), orderState(serverName))
+	}
+	return fmt.Errorf("failed to obtain certificate for %s: %w", serverName, err)
+}
+
+// account returns a client for the registered account, loading or creating the account key
+// and registering it on first use.
+func (g *Generator) account(ctx context.Context) (*acme.Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client != nil {
+		return g.client, nil
+	}
+	key, err := g.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: g.cfg.DirectoryURL,
+		HTTPClient:   g.cfg.HTTPClient,
+		UserAgent:    "airtls",
+	}
+	_, err = client.Register(ctx, &acme.Account{Contact: g.cfg.Contact}, g.cfg.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+	g.client = client
+	return client, nil
+}
+
+func (g *Generator) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := g.cfg.State.GetState(ctx, accountKeyState)
+	if err == nil {
+		return parseKey(data)
+	}
+	if !errors.Is(err, ErrStateNotFound) {
+		return nil, fmt.Errorf("failed to load account key: %w", err)
+	}
+	key, err := newECDSAKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate account key: %w", err)
+	}
+	data, err = marshalKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.cfg.State.SetState(ctx, accountKeyState, data); err != nil {
+		return nil, fmt.Errorf("failed to store account key: %w", err)
+	}
+	return key, nil
+}
+
+func declineTOS(string) bool {
+	return false
+}
+
+func orderState(serverName string) string {
+	return "order+" + serverName
+}
+
+func csrTemplate(serverName string) *x509.CertificateRequest {
+	tmpl := &x509.CertificateRequest{Subject: pkix.Name{CommonName: serverName}}
+	if ip := net.ParseIP(serverName); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{serverName}
+	}
+	return tmpl
+}
+
+func newECDSAKey() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+func marshalKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("key is not a signer")
+	}
+	return signer, nil
+}
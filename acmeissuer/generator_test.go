@@ -0,0 +1,311 @@
+package acmeissuer_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/acmeissuer"
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/https"
+	"github.com/deployport/airtls/selfsigned"
+	"golang.org/x/crypto/acme"
+)
+
+// idPeACMEIdentifier is the OID of the acmeIdentifier extension of tls-alpn-01 challenge certificates
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+func TestHTTP01Solver(t *testing.T) {
+	client := newTestClient(t)
+	solver := acmeissuer.NewHTTP01Solver()
+	chal := &acme.Challenge{Type: "http-01", Token: "token123"}
+	if err := solver.Present(t.Context(), client, "example.com", chal); err != nil {
+		t.Fatalf("Present failed: %v", err)
+	}
+	want, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		t.Fatalf("HTTP01ChallengeResponse failed: %v", err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "next")
+	})
+	handler := solver.Handler(next)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token123", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Fatalf("expected key authorization, got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/other", nil))
+	if rec.Body.String() != "next" {
+		t.Fatalf("expected other paths to reach next handler, got %q", rec.Body.String())
+	}
+
+	if err := solver.CleanUp(t.Context(), "example.com", chal); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token123", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected cleaned up token to be gone, got %d", rec.Code)
+	}
+}
+
+func TestTLSALPN01Solver(t *testing.T) {
+	client := newTestClient(t)
+	solver := acmeissuer.NewTLSALPN01Solver()
+	chal := &acme.Challenge{Type: "tls-alpn-01", Token: "token123"}
+	if err := solver.Present(t.Context(), client, "example.com", chal); err != nil {
+		t.Fatalf("Present failed: %v", err)
+	}
+	cert, ok := solver.ChallengeCertificate("example.com")
+	if !ok {
+		t.Fatal("expected a challenge certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse challenge certificate: %v", err)
+	}
+	found := false
+	for _, ext := range leaf.Extensions {
+		found = found || ext.Id.Equal(idPeACMEIdentifier)
+	}
+	if !found {
+		t.Error("expected challenge certificate to carry the acmeIdentifier extension")
+	}
+	if err := solver.CleanUp(t.Context(), "example.com", chal); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, ok := solver.ChallengeCertificate("example.com"); ok {
+		t.Error("expected cleaned up challenge certificate to be gone")
+	}
+}
+
+func TestNewRequiresSolver(t *testing.T) {
+	if _, err := acmeissuer.New(); err == nil {
+		t.Fatal("expected error without solvers")
+	}
+}
+
+func TestGeneratorFakeCA(t *testing.T) {
+	const domain = "example.com"
+	newGenerator := func(t *testing.T, ca *fakeCA, solver *fakeSolver) (*acmeissuer.Generator, acmeissuer.StateStore) {
+		t.Helper()
+		state := acmeissuer.NewMemoryStateStore()
+		generator, err := acmeissuer.New(
+			acmeissuer.WithDirectoryURL(ca.DirectoryURL()),
+			acmeissuer.WithStateStore(state),
+			acmeissuer.WithSolvers(solver),
+		)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		return generator, state
+	}
+	hasOrder := func(t *testing.T, state acmeissuer.StateStore) bool {
+		t.Helper()
+		_, err := state.GetState(t.Context(), "order+"+domain)
+		if err != nil && !errors.Is(err, acmeissuer.ErrStateNotFound) {
+			t.Fatalf("GetState failed: %v", err)
+		}
+		return err == nil
+	}
+
+	t.Run("issues certificate", func(t *testing.T) {
+		ca := newFakeCA(t)
+		generator, state := newGenerator(t, ca, &fakeSolver{})
+		cert := generate(t, generator, domain)
+		signer, ok := cert.PrivateKey.(crypto.Signer)
+		if !ok || !cert.Leaf.PublicKey.(*ecdsa.PublicKey).Equal(signer.Public()) {
+			t.Error("expected private key to match the certificate")
+		}
+		if hasOrder(t, state) {
+			t.Error("expected completed order to be removed from the state")
+		}
+	})
+	t.Run("resumes order after failure", func(t *testing.T) {
+		ca := newFakeCA(t)
+		generator, state := newGenerator(t, ca, &fakeSolver{failPresent: 1})
+		if _, err := generator.GenerateContext(t.Context(), domain); err == nil {
+			t.Fatal("expected first attempt to fail")
+		}
+		if !hasOrder(t, state) {
+			t.Fatal("expected pending order to be kept")
+		}
+		generate(t, generator, domain)
+		if ca.NewOrders() != 1 {
+			t.Errorf("expected the order to be resumed, got %d orders", ca.NewOrders())
+		}
+	})
+	t.Run("discards invalid order", func(t *testing.T) {
+		ca := newFakeCA(t)
+		ca.FailValidations(1)
+		generator, state := newGenerator(t, ca, &fakeSolver{})
+		if _, err := generator.GenerateContext(t.Context(), domain); err == nil {
+			t.Fatal("expected failed validation to fail")
+		}
+		if hasOrder(t, state) {
+			t.Fatal("expected invalid order to be discarded")
+		}
+		generate(t, generator, domain)
+		if ca.NewOrders() != 2 {
+			t.Errorf("expected a new order, got %d orders", ca.NewOrders())
+		}
+	})
+	t.Run("waits for processing order", func(t *testing.T) {
+		ca := newFakeCA(t)
+		ca.SetProcessing(-1)
+		generator, state := newGenerator(t, ca, &fakeSolver{})
+		ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+		defer cancel()
+		if _, err := generator.GenerateContext(ctx, domain); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected issuance to time out, got %v", err)
+		}
+		if !hasOrder(t, state) {
+			t.Fatal("expected processing order to be kept")
+		}
+		// the resumed order is still processing when fetched, then issued
+		ca.SetProcessing(1)
+		generate(t, generator, domain)
+		if ca.NewOrders() != 1 {
+			t.Errorf("expected the order to be resumed, got %d orders", ca.NewOrders())
+		}
+	})
+}
+
+// TestGeneratorPebble runs against a local Pebble ACME test server, configured through:
+//   - AIRTLS_PEBBLE_DIRECTORY: directory URL, such as https://localhost:14000/dir (required, skipped otherwise)
+//   - AIRTLS_PEBBLE_DOMAIN: name to issue for, resolving to this host, "localhost" by default
+//   - AIRTLS_PEBBLE_HTTP_ADDR: address Pebble validates http-01 on, ":5002" by default
+//   - AIRTLS_PEBBLE_TLS_ADDR: address Pebble validates tls-alpn-01 on, ":5001" by default
+func TestGeneratorPebble(t *testing.T) {
+	directory := os.Getenv("AIRTLS_PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("AIRTLS_PEBBLE_DIRECTORY not set")
+	}
+	domain := envOr("AIRTLS_PEBBLE_DOMAIN", "localhost")
+	// Pebble serves its API with a test certificate
+	httpClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	t.Run("http-01", func(t *testing.T) {
+		solver := acmeissuer.NewHTTP01Solver()
+		ln, err := net.Listen("tcp", envOr("AIRTLS_PEBBLE_HTTP_ADDR", ":5002"))
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		srv := &http.Server{Handler: solver.Handler(nil)}
+		go srv.Serve(ln)
+		defer srv.Close()
+
+		state := acmeissuer.NewMemoryStateStore()
+		generator, err := acmeissuer.New(
+			acmeissuer.WithDirectoryURL(directory),
+			acmeissuer.WithAcceptTOS(),
+			acmeissuer.WithHTTPClient(httpClient),
+			acmeissuer.WithStateStore(state),
+			acmeissuer.WithSolvers(solver),
+		)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		cert := generate(t, generator, domain)
+		if _, err := state.GetState(t.Context(), "account.key"); err != nil {
+			t.Errorf("expected account key to be persisted, got %v", err)
+		}
+
+		// a second generator sharing the state reuses the registered account
+		again, err := acmeissuer.New(
+			acmeissuer.WithDirectoryURL(directory),
+			acmeissuer.WithHTTPClient(httpClient),
+			acmeissuer.WithStateStore(state),
+			acmeissuer.WithSolvers(solver),
+		)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if renewed := generate(t, again, domain); renewed.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) == 0 {
+			t.Error("expected a new certificate")
+		}
+	})
+	t.Run("tls-alpn-01", func(t *testing.T) {
+		solver := acmeissuer.NewTLSALPN01Solver()
+		ctx, cancel := context.WithCancel(t.Context())
+		served := make(chan error, 1)
+		defer func() {
+			cancel()
+			if err := <-served; err != nil {
+				t.Errorf("ServeHTTPS failed: %v", err)
+			}
+		}()
+		// the TLS server answering validation handshakes is the regular server,
+		// regular handshakes get self-signed certificates here
+		go func() {
+			served <- https.ServeHTTPS(
+				ctx,
+				selfsigned.NewGenerator(),
+				caching.NewMemoryStore(),
+				envOr("AIRTLS_PEBBLE_TLS_ADDR", ":5001"),
+				http.NotFoundHandler(),
+				https.WithGetCertificateOptions(https.WithChallengeProvider(solver)),
+			)
+		}()
+
+		generator, err := acmeissuer.New(
+			acmeissuer.WithDirectoryURL(directory),
+			acmeissuer.WithAcceptTOS(),
+			acmeissuer.WithHTTPClient(httpClient),
+			acmeissuer.WithSolvers(solver),
+		)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		generate(t, generator, domain)
+	})
+}
+
+func generate(t *testing.T, generator *acmeissuer.Generator, domain string) *tls.Certificate {
+	t.Helper()
+	cert, err := generator.GenerateContext(context.Background(), domain)
+	if err != nil {
+		t.Fatalf("GenerateContext failed: %v", err)
+	}
+	if err := cert.Leaf.VerifyHostname(domain); err != nil {
+		t.Errorf("certificate does not match %s: %v", domain, err)
+	}
+	if len(cert.Certificate) < 2 {
+		t.Errorf("expected certificate chain with issuer, got %d certificates", len(cert.Certificate))
+	}
+	return cert
+}
+
+func newTestClient(t *testing.T) *acme.Client {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return &acme.Client{Key: key}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,127 @@
+package acmeissuer
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Solver answers one type of ACME challenge.
+type Solver interface {
+	// ChallengeType is the ACME challenge type solved, such as "http-01" or "tls-alpn-01".
+	ChallengeType() string
+	// Present makes the response to chal available for domain before the CA is asked to validate it.
+	Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error
+	// CleanUp removes the response to chal once the authorization completed.
+	CleanUp(ctx context.Context, domain string, chal *acme.Challenge) error
+}
+
+// HTTP01Solver solves http-01 challenges by serving key authorizations
+// under /.well-known/acme-challenge/ on port 80, see Handler.
+type HTTP01Solver struct {
+	mu        sync.RWMutex
+	responses map[string]string
+}
+
+// NewHTTP01Solver creates a new HTTP01Solver instance.
+func NewHTTP01Solver() *HTTP01Solver {
+	return &HTTP01Solver{responses: make(map[string]string)}
+}
+
+// ChallengeType returns "http-01".
+func (s *HTTP01Solver) ChallengeType() string {
+	return "http-01"
+}
+
+// Present registers the key authorization of chal so Handler serves it.
+func (s *HTTP01Solver) Present(_ context.Context, client *acme.Client, _ string, chal *acme.Challenge) error {
+	response, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.responses[client.HTTP01ChallengePath(chal.Token)] = response
+	s.mu.Unlock()
+	return nil
+}
+
+// CleanUp stops serving the key authorization of chal.
+func (s *HTTP01Solver) CleanUp(_ context.Context, _ string, chal *acme.Challenge) error {
+	s.mu.Lock()
+	delete(s.responses, (&acme.Client{}).HTTP01ChallengePath(chal.Token))
+	s.mu.Unlock()
+	return nil
+}
+
+// Handler returns a handler serving pending challenge responses, passing any other request to next.
+// next defaults to http.NotFoundHandler when nil.
+func (s *HTTP01Solver) Handler(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.mu.RLock()
+		response, ok := s.responses[r.URL.Path]
+		s.mu.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(response))
+	})
+}
+
+// TLSALPN01Solver solves tls-alpn-01 challenges by serving a challenge certificate
+// to handshakes negotiating the "acme-tls/1" protocol on port 443, see ChallengeCertificate.
+type TLSALPN01Solver struct {
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate
+}
+
+// NewTLSALPN01Solver creates a new TLSALPN01Solver instance.
+func NewTLSALPN01Solver() *TLSALPN01Solver {
+	return &TLSALPN01Solver{certs: make(map[string]*tls.Certificate)}
+}
+
+// ChallengeType returns "tls-alpn-01".
+func (s *TLSALPN01Solver) ChallengeType() string {
+	return "tls-alpn-01"
+}
+
+// Present creates the challenge certificate of chal for domain.
+func (s *TLSALPN01Solver) Present(_ context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	cert, err := client.TLSALPN01ChallengeCert(chal.Token, domain)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.certs[domain] = &cert
+	s.mu.Unlock()
+	return nil
+}
+
+// CleanUp removes the challenge certificate for domain.
+func (s *TLSALPN01Solver) CleanUp(_ context.Context, domain string, _ *acme.Challenge) error {
+	s.mu.Lock()
+	delete(s.certs, domain)
+	s.mu.Unlock()
+	return nil
+}
+
+// ChallengeCertificate returns the pending challenge certificate for serverName, if any.
+// It must only be served to handshakes negotiating the "acme-tls/1" protocol.
+func (s *TLSALPN01Solver) ChallengeCertificate(serverName string) (*tls.Certificate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cert, ok := s.certs[serverName]
+	return cert, ok
+}
@@ -0,0 +1,117 @@
+package acmeissuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrStateNotFound is returned by a StateStore when no state is stored under a key.
+var ErrStateNotFound = errors.New("acme state not found")
+
+// StateStore persists the ACME account key and in-progress orders, so a restarted process
+// keeps its account and resumes orders instead of creating new ones.
+type StateStore interface {
+	// GetState returns the data stored under key, or ErrStateNotFound.
+	GetState(ctx context.Context, key string) ([]byte, error)
+	// SetState stores data under key.
+	SetState(ctx context.Context, key string, data []byte) error
+	// DeleteState removes the data stored under key, it is not an error if there is none.
+	DeleteState(ctx context.Context, key string) error
+}
+
+// MemoryStateStore is a concurrent in-memory StateStore, the state is lost on restart.
+type MemoryStateStore struct {
+	mu    sync.RWMutex
+	state map[string][]byte
+}
+
+// NewMemoryStateStore creates a new MemoryStateStore instance.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{state: make(map[string][]byte)}
+}
+
+// GetState returns the data stored under key.
+func (m *MemoryStateStore) GetState(_ context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.state[key]
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	return data, nil
+}
+
+// SetState stores data under key.
+func (m *MemoryStateStore) SetState(_ context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	m.state[key] = data
+	m.mu.Unlock()
+	return nil
+}
+
+// DeleteState removes the data stored under key.
+func (m *MemoryStateStore) DeleteState(_ context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.state, key)
+	m.mu.Unlock()
+	return nil
+}
+
+// DirStateStore is a StateStore keeping one file per key in a directory.
+// Files are written with 0600 permissions since they hold private keys.
+type DirStateStore struct {
+	dir string
+}
+
+// NewDirStateStore creates a new DirStateStore in dir, creating it if needed.
+func NewDirStateStore(dir string) (*DirStateStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return &DirStateStore{dir: dir}, nil
+}
+
+// GetState returns the data stored under key.
+func (d *DirStateStore) GetState(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrStateNotFound
+	}
+	return data, err
+}
+
+// SetState stores data under key, replacing the file atomically.
+func (d *DirStateStore) SetState(_ context.Context, key string, data []byte) error {
+	tmp, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(key))
+}
+
+// DeleteState removes the data stored under key.
+func (d *DirStateStore) DeleteState(_ context.Context, key string) error {
+	err := os.Remove(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path maps key to a file name inside the directory, keys never escape it.
+func (d *DirStateStore) path(key string) string {
+	return filepath.Join(d.dir, strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(key))
+}
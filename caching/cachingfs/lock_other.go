@@ -0,0 +1,37 @@
+//go:build !unix
+
+package cachingfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// staleLockAge is the age after which a lock file left behind by a crashed process is removed
+const staleLockAge = 5 * time.Minute
+
+// lockFile takes an exclusive lock by creating path exclusively, polling until it is created or ctx is done.
+// Without flock a crashed holder leaves the file behind, it is considered stale after staleLockAge.
+func lockFile(ctx context.Context, path string, pollEvery time.Duration) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollEvery):
+		}
+	}
+}
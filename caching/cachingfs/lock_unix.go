@@ -0,0 +1,68 @@
+//go:build unix
+
+package cachingfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockFile takes an exclusive flock on path, polling until it is acquired or ctx is done.
+// The lock is released by the kernel if the process dies. Unlocking removes the file while still
+// holding the lock, a waiter that acquires the lock on the removed file starts over with a new one.
+func lockFile(ctx context.Context, path string, pollEvery time.Duration) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		if err := flockFile(ctx, f, pollEvery); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if !isCurrentFile(f, path) {
+			// removed by the previous holder while waiting
+			f.Close()
+			continue
+		}
+		return func() {
+			os.Remove(path)
+			syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+			f.Close()
+		}, nil
+	}
+}
+
+// flockFile takes an exclusive flock on f, polling until it is acquired or ctx is done.
+func flockFile(ctx context.Context, f *os.File, pollEvery time.Duration) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollEvery):
+		}
+	}
+}
+
+// isCurrentFile reports whether f is still the file at path
+func isCurrentFile(f *os.File, path string) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
@@ -0,0 +1,241 @@
+package cachingfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deployport/airtls/certencoding"
+	"github.com/deployport/airtls/certencoding/json"
+	"github.com/deployport/airtls/store"
+)
+
+// lockDir is the subdirectory holding lock files, escaped names never start with a dot so it cannot collide
+const lockDir = ".locks"
+
+// DirStore implements a certificate store keeping one file per server name in a directory.
+//
+// Files are written atomically through a temporary file renamed in place, readable by the owner only
+// since they hold private keys. Writers sharing the directory, including other processes,
+// are serialized with file locks, and readers always see a complete file.
+type DirStore struct {
+	dir       string
+	codec     certencoding.Codec
+	extension string
+	pollEvery time.Duration
+}
+
+// DirStoreOption configures a DirStore.
+type DirStoreOption func(*DirStore)
+
+// WithCodec sets the encoding of certificate files, JSON by default.
+func WithCodec(codec certencoding.Codec) DirStoreOption {
+	return func(d *DirStore) {
+		d.codec = codec
+	}
+}
+
+// WithFileExtension sets the extension of certificate files, ".cert" by default.
+func WithFileExtension(extension string) DirStoreOption {
+	return func(d *DirStore) {
+		d.extension = extension
+	}
+}
+
+// New creates a new DirStore in dir, creating the directory with 0700 permissions if needed.
+func New(dir string, opts ...DirStoreOption) (*DirStore, error) {
+	d := &DirStore{
+		dir:       dir,
+		codec:     &json.Marshaler{},
+		extension: ".cert",
+		pollEvery: 50 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, lockDir), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	return d, nil
+}
+
+// GetCertificate retrieves a certificate by server name from its file.
+func (d *DirStore) GetCertificate(serverName string) (*tls.Certificate, error) {
+	return d.GetCertificateContext(context.Background(), serverName)
+}
+
+// GetCertificateContext retrieves a certificate by server name from its file.
+// Reading is not interruptible, so ctx is only checked before reading.
+func (d *DirStore) GetCertificateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(d.path(serverName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, store.NewCertificateNotFoundError()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	cert, err := d.codec.Unmarshal(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate file: %w", err)
+	}
+	return cert, nil
+}
+
+// SetCertificate stores a certificate by server name in its file.
+func (d *DirStore) SetCertificate(serverName string, cert tls.Certificate) error {
+	return d.SetCertificateContext(context.Background(), serverName, cert)
+}
+
+// SetCertificateContext stores a certificate by server name in its file,
+// giving up when ctx is done while waiting for another writer.
+func (d *DirStore) SetCertificateContext(ctx context.Context, serverName string, cert tls.Certificate) error {
+	var buf bytes.Buffer
+	if err := d.codec.Marshal(cert, &buf); err != nil {
+		return fmt.Errorf("failed to encode certificate: %w", err)
+	}
+	unlock, err := d.lock(ctx, serverName, "write")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return d.writeFile(d.path(serverName), buf.Bytes())
+}
+
+// DeleteCertificate removes the file of serverName, waiting for a concurrent writer to finish first.
+func (d *DirStore) DeleteCertificate(ctx context.Context, serverName string) error {
+	unlock, err := d.lock(ctx, serverName, "write")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(d.path(serverName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove certificate file: %w", err)
+	}
+	return nil
+}
+
+// ListCertificates returns the server names of the certificate files in the directory, lowercased.
+func (d *DirStore) ListCertificates(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		fileName := entry.Name()
+		// temporary and lock files start with a dot, escaped names never do
+		if !entry.Type().IsRegular() || strings.HasPrefix(fileName, ".") || !strings.HasSuffix(fileName, d.extension) {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(fileName, d.extension))
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// StatCertificate returns metadata about the certificate of serverName.
+// When the codec implements certencoding.LeafUnmarshaler the private key is not decoded.
+func (d *DirStore) StatCertificate(ctx context.Context, serverName string) (*store.CertificateInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(d.path(serverName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, store.NewCertificateNotFoundError()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	var leaf *x509.Certificate
+	if leafUnmarshaler, ok := d.codec.(certencoding.LeafUnmarshaler); ok {
+		leaf, err = leafUnmarshaler.UnmarshalLeaf(bytes.NewReader(data))
+	} else {
+		var cert *tls.Certificate
+		if cert, err = d.codec.Unmarshal(bytes.NewReader(data)); err == nil {
+			leaf, err = store.CertificateLeaf(cert)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate file: %w", err)
+	}
+	return store.NewCertificateInfo(serverName, leaf), nil
+}
+
+// Lock implements store.Locker, serializing certificate generation for serverName
+// across processes sharing the directory. The lock file under .locks is removed on unlock.
+func (d *DirStore) Lock(ctx context.Context, serverName string) (func(), error) {
+	return d.lock(ctx, serverName, "generate")
+}
+
+func (d *DirStore) lock(ctx context.Context, serverName, purpose string) (func(), error) {
+	path := filepath.Join(d.dir, lockDir, escapeName(serverName)+"."+purpose)
+	unlock, err := lockFile(ctx, path, d.pollEvery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", serverName, err)
+	}
+	return unlock, nil
+}
+
+// writeFile atomically replaces path with data through a temporary file in the same directory.
+func (d *DirStore) writeFile(path string, data []byte) error {
+	// CreateTemp creates the file with 0600 permissions
+	tmp, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename certificate file: %w", err)
+	}
+	return nil
+}
+
+func (d *DirStore) path(serverName string) string {
+	return filepath.Join(d.dir, escapeName(serverName)+d.extension)
+}
+
+// escapeName maps a server name to a safe file name. Names are lowercased, letters, digits, '-', '_' and
+// non-leading dots are kept and any other byte is percent-encoded, so names can never escape the directory
+// or collide with temporary and lock files.
+func escapeName(serverName string) string {
+	name := strings.ToLower(serverName)
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,236 @@
+package cachingfs_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/caching/cachingfs"
+	"github.com/deployport/airtls/https"
+	"github.com/deployport/airtls/selfsigned"
+	"github.com/deployport/airtls/store"
+)
+
+func TestDirStore(t *testing.T) {
+	generator := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+	cert, err := generator.Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		dir := t.TempDir()
+		dirStore, err := cachingfs.New(dir)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if _, err := dirStore.GetCertificate("example.com"); !store.IsCertificateNotFound(err) {
+			t.Fatalf("expected certificate not found, got %v", err)
+		}
+		if err := dirStore.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		// a new store on the same directory, as after a restart
+		reopened, err := cachingfs.New(dir)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		retrieved, err := reopened.GetCertificate("EXAMPLE.com")
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if !retrieved.Leaf.Equal(cert.Leaf) {
+			t.Error("expected retrieved certificate to match stored certificate")
+		}
+		info, err := os.Stat(filepath.Join(dir, "example.com.cert"))
+		if err != nil {
+			t.Fatalf("failed to stat certificate file: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("expected certificate file permissions 0600, got %o", perm)
+		}
+	})
+	t.Run("path traversal", func(t *testing.T) {
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "certs")
+		dirStore, err := cachingfs.New(dir)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		for _, name := range []string{"../escape", "..", "a/../../escape", `..\escape`, ".locks"} {
+			if err := dirStore.SetCertificate(name, *cert); err != nil {
+				t.Fatalf("SetCertificate(%q) failed: %v", name, err)
+			}
+			if _, err := dirStore.GetCertificate(name); err != nil {
+				t.Fatalf("GetCertificate(%q) failed: %v", name, err)
+			}
+		}
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatalf("failed to read parent directory: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected files to stay inside the store directory, parent has %d entries", len(entries))
+		}
+	})
+	t.Run("concurrent writers", func(t *testing.T) {
+		dir := t.TempDir()
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// separate instances behave like separate processes sharing the directory
+				dirStore, err := cachingfs.New(dir)
+				if err != nil {
+					t.Errorf("New failed: %v", err)
+					return
+				}
+				if err := dirStore.SetCertificate("example.com", *cert); err != nil {
+					t.Errorf("SetCertificate failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		dirStore, err := cachingfs.New(dir)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if _, err := dirStore.GetCertificate("example.com"); err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+	})
+	t.Run("generation lock", func(t *testing.T) {
+		dir := t.TempDir()
+		first, err := cachingfs.New(dir)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		second, err := cachingfs.New(dir)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		unlock, err := first.Lock(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := second.Lock(ctx, "example.com"); err == nil {
+			t.Fatal("expected lock held by another instance to time out")
+		}
+		unlock()
+		unlock, err = second.Lock(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("Lock after release failed: %v", err)
+		}
+		unlock()
+
+		// contended locks stay exclusive while every holder removes the lock file
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		holders := 0
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 5 {
+					unlock, err := first.Lock(t.Context(), "example.com")
+					if err != nil {
+						t.Errorf("Lock failed: %v", err)
+						return
+					}
+					mu.Lock()
+					holders++
+					if holders > 1 {
+						t.Error("expected a single lock holder")
+					}
+					mu.Unlock()
+					time.Sleep(time.Millisecond)
+					mu.Lock()
+					holders--
+					mu.Unlock()
+					unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		entries, err := os.ReadDir(filepath.Join(dir, ".locks"))
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected released lock files to be removed, got %d", len(entries))
+		}
+	})
+	t.Run("delete list stat", func(t *testing.T) {
+		dirStore, err := cachingfs.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		for _, name := range []string{"example.com", "*.example.com"} {
+			if err := dirStore.SetCertificate(name, *cert); err != nil {
+				t.Fatalf("SetCertificate(%q) failed: %v", name, err)
+			}
+		}
+		names, err := dirStore.ListCertificates(t.Context())
+		if err != nil {
+			t.Fatalf("ListCertificates failed: %v", err)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, []string{"*.example.com", "example.com"}) {
+			t.Errorf("expected escaped names to be listed as stored, got %v", names)
+		}
+		info, err := dirStore.StatCertificate(t.Context(), "*.example.com")
+		if err != nil {
+			t.Fatalf("StatCertificate failed: %v", err)
+		}
+		if !info.NotAfter.Equal(cert.Leaf.NotAfter) || info.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+			t.Errorf("unexpected certificate info %+v", info)
+		}
+		if err := dirStore.DeleteCertificate(t.Context(), "*.example.com"); err != nil {
+			t.Fatalf("DeleteCertificate failed: %v", err)
+		}
+		if err := dirStore.DeleteCertificate(t.Context(), "*.example.com"); err != nil {
+			t.Fatalf("DeleteCertificate of a missing certificate failed: %v", err)
+		}
+		if _, err := dirStore.StatCertificate(t.Context(), "*.example.com"); !store.IsCertificateNotFound(err) {
+			t.Errorf("expected deleted certificate to be not found, got %v", err)
+		}
+	})
+}
+
+// ExampleNew demonstrates how to keep certificates across restarts with an in-memory tier over a directory.
+func ExampleNew() {
+	ctx := context.Background()
+	dirStore, err := cachingfs.New("/var/lib/airtls/certs")
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello, HTTPS with certificates kept on disk!"))
+	})
+
+	err = https.ServeHTTPS(
+		ctx,
+		selfsigned.NewGenerator(),
+		// the memory tier warms up from disk after a restart
+		caching.NewTieredStoreWithOptions(
+			[]store.Store{caching.NewMemoryStore(), dirStore},
+			caching.WithBackfill(caching.BackfillAsync),
+		),
+		":443",
+		handler,
+		https.WithGetCertificateOptions(https.WithLocker(dirStore, https.LockFallbackFail)),
+	)
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+}
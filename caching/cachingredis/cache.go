@@ -1,37 +1,79 @@
 package cachingredis
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/deployport/airtls/certencoding"
 	"github.com/deployport/airtls/certencoding/json"
 	"github.com/deployport/airtls/store"
 	redis "github.com/redis/go-redis/v9"
 )
 
 // RedisCache implements a certificate cache using Redis as the backend.
+//
+// Entries expire with their certificate: the Redis TTL is set from the certificate NotAfter,
+// shortened by the expiry margin and capped by the maximum TTL when configured.
+//
+// Any redis.UniversalClient is supported: a single node, Sentinel failover, Cluster or Ring.
+// Every command touches a single key and keys carry no hash tag, so entries spread over all cluster slots.
 type RedisCache struct {
-	client *redis.Client
-	prefix string
+	client              redis.UniversalClient
+	prefix              string
+	expiryMargin        time.Duration
+	maxTTL              time.Duration
+	channel             string
+	nodeID              string
+	publishErrorHandler func(err error)
+	format              string
+	codec               certencoding.Codec
+	decoders            map[string]certencoding.Unmarshaler
 }
 
 // RedisCacheOption configures a RedisCache.
 type RedisCacheOption func(*RedisCache)
 
 // WithPrefix sets the prefix for cache entries in Redis.
+// Every key under the prefix is taken as a certificate by ListCertificates, so it must not be shared with other data.
 func WithPrefix(prefix string) RedisCacheOption {
 	return func(c *RedisCache) {
 		c.prefix = prefix
 	}
 }
 
+// WithExpiryMargin sets how long before the certificate NotAfter its entry expires,
+// so certificates about to expire are not served, for example because of clock skew between hosts.
+// The margin is capped to a quarter of the certificate lifetime, so short-lived certificates are still stored.
+func WithExpiryMargin(margin time.Duration) RedisCacheOption {
+	return func(c *RedisCache) {
+		c.expiryMargin = margin
+	}
+}
+
+// WithMaxTTL caps the Redis TTL of entries, so certificates are reloaded from the lower tiers
+// or regenerated at least that often. Zero, the default, keeps entries until their certificate expires.
+func WithMaxTTL(ttl time.Duration) RedisCacheOption {
+	return func(c *RedisCache) {
+		c.maxTTL = ttl
+	}
+}
+
 // New creates a new RedisCache with the given Redis client and options.
-func New(client *redis.Client, opts ...RedisCacheOption) *RedisCache {
+func New(client redis.UniversalClient, opts ...RedisCacheOption) *RedisCache {
 	cache := &RedisCache{
 		client: client,
 		prefix: "airtls:",
+		nodeID: newNodeID(),
+		format: FormatJSON,
+		codec:  &json.Marshaler{},
+		decoders: map[string]certencoding.Unmarshaler{
+			FormatJSON: &json.Marshaler{},
+		},
 	}
 	for _, opt := range opts {
 		opt(cache)
@@ -43,9 +85,14 @@ func (c *RedisCache) key(serverName string) string {
 	return c.prefix + serverName
 }
 
-// GetCertificate retrieves a certificate by server name from Redis using JSON marshaling.
+// GetCertificate retrieves a certificate by server name from Redis, decoding it with the decoder of its format.
 func (c *RedisCache) GetCertificate(serverName string) (*tls.Certificate, error) {
-	ctx := context.Background()
+	return c.GetCertificateContext(context.Background(), serverName)
+}
+
+// GetCertificateContext retrieves a certificate by server name from Redis, decoding it with the decoder of its format,
+// aborting the Redis round trip when ctx is done. Expired certificates are not found.
+func (c *RedisCache) GetCertificateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
 	val, err := c.client.Get(ctx, c.key(serverName)).Bytes()
 	if err == redis.Nil {
 		return nil, store.NewCertificateNotFoundError()
@@ -53,24 +100,143 @@ func (c *RedisCache) GetCertificate(serverName string) (*tls.Certificate, error)
 	if err != nil {
 		return nil, fmt.Errorf("redis get error: %w", err)
 	}
-	marshaler := json.Marshaler{}
-	cert, err := marshaler.Unmarshal(bytes.NewReader(val))
+	cert, err := c.decode(val)
 	if err != nil {
-		return nil, fmt.Errorf("json unmarshal error: %w", err)
+		return nil, err
+	}
+	// the TTL normally removes expired entries, this covers entries written without one
+	if leaf, err := store.CertificateLeaf(cert); err == nil && c.expired(leaf, time.Now()) {
+		return nil, store.NewCertificateNotFoundError()
 	}
 	return cert, nil
 }
 
-// SetCertificate stores a certificate by server name in Redis using JSON marshaling.
+// SetCertificate stores a certificate by server name in Redis, encoded with the configured codec, JSON by default.
 func (c *RedisCache) SetCertificate(serverName string, cert tls.Certificate) error {
-	ctx := context.Background()
-	var buf bytes.Buffer
-	marshaler := json.Marshaler{}
-	if err := marshaler.Marshal(cert, &buf); err != nil {
-		return fmt.Errorf("json marshal error: %w", err)
+	return c.SetCertificateContext(context.Background(), serverName, cert)
+}
+
+// SetCertificateContext stores a certificate by server name in Redis, encoded with the configured codec,
+// aborting the Redis round trip when ctx is done.
+// An already expired certificate is not stored and replaces the entry by deleting it.
+// With an invalidation channel, an InvalidationSet event is published once stored, see WithPublishErrorHandler.
+func (c *RedisCache) SetCertificateContext(ctx context.Context, serverName string, cert tls.Certificate) error {
+	val, err := c.encode(cert)
+	if err != nil {
+		return err
 	}
-	if err := c.client.Set(ctx, c.key(serverName), buf.Bytes(), 0).Err(); err != nil {
+	ttl := c.maxTTL
+	if leaf, err := store.CertificateLeaf(&cert); err == nil {
+		ttl = c.ttl(leaf, time.Now())
+		if ttl <= 0 {
+			return c.DeleteCertificate(ctx, serverName)
+		}
+	}
+	if err := c.client.Set(ctx, c.key(serverName), val, ttl).Err(); err != nil {
 		return fmt.Errorf("redis set error: %w", err)
 	}
+	c.publish(ctx, InvalidationSet, serverName)
 	return nil
 }
+
+// ttl returns the Redis TTL of leaf, zero or less when it has expired
+func (c *RedisCache) ttl(leaf *x509.Certificate, now time.Time) time.Duration {
+	ttl := leaf.NotAfter.Add(-c.margin(leaf)).Sub(now)
+	// Redis expires with millisecond precision, a shorter TTL would be rejected
+	if ttl < time.Millisecond {
+		return 0
+	}
+	if c.maxTTL > 0 && ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+	return ttl
+}
+
+func (c *RedisCache) expired(leaf *x509.Certificate, now time.Time) bool {
+	return !now.Before(leaf.NotAfter.Add(-c.margin(leaf)))
+}
+
+// margin returns the expiry margin of leaf, capped to a quarter of its lifetime
+func (c *RedisCache) margin(leaf *x509.Certificate) time.Duration {
+	if limit := leaf.NotAfter.Sub(leaf.NotBefore) / 4; c.expiryMargin > limit {
+		return limit
+	}
+	return c.expiryMargin
+}
+
+// DeleteCertificate removes the certificate of serverName from Redis.
+// With an invalidation channel, an InvalidationDelete event is published once deleted, see WithPublishErrorHandler.
+func (c *RedisCache) DeleteCertificate(ctx context.Context, serverName string) error {
+	if err := c.client.Del(ctx, c.key(serverName)).Err(); err != nil {
+		return fmt.Errorf("redis del error: %w", err)
+	}
+	c.publish(ctx, InvalidationDelete, serverName)
+	return nil
+}
+
+// ListCertificates returns the server names stored under the prefix, scanning the keyspace incrementally
+// so Redis is not blocked. Keys added or removed during the scan may or may not be returned.
+// Cluster masters and Ring shards are each scanned, since SCAN only covers the node it runs on.
+func (c *RedisCache) ListCertificates(ctx context.Context) ([]string, error) {
+	var mu sync.Mutex
+	var names []string
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, escapeGlob(c.prefix)+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			names = append(names, strings.TrimPrefix(iter.Val(), c.prefix))
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	var err error
+	switch client := c.client.(type) {
+	case *redis.ClusterClient:
+		err = client.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scan(ctx, node)
+		})
+	case *redis.Ring:
+		err = client.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return scan(ctx, shard)
+		})
+	default:
+		err = scan(ctx, c.client)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis scan error: %w", err)
+	}
+	return names, nil
+}
+
+// StatCertificate returns metadata about the certificate of serverName,
+// without decoding its private key when the decoder of its format implements certencoding.LeafUnmarshaler.
+func (c *RedisCache) StatCertificate(ctx context.Context, serverName string) (*store.CertificateInfo, error) {
+	val, err := c.client.Get(ctx, c.key(serverName)).Bytes()
+	if err == redis.Nil {
+		return nil, store.NewCertificateNotFoundError()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis get error: %w", err)
+	}
+	leaf, err := c.decodeLeaf(val)
+	if err != nil {
+		return nil, err
+	}
+	if c.expired(leaf, time.Now()) {
+		return nil, store.NewCertificateNotFoundError()
+	}
+	return store.NewCertificateInfo(serverName, leaf), nil
+}
+
+// escapeGlob escapes the characters SCAN MATCH patterns treat specially
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -2,14 +2,222 @@ package cachingredis_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"net/http"
+	"slices"
+	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/deployport/airtls/caching/cachingredis"
 	"github.com/deployport/airtls/https"
 	"github.com/deployport/airtls/selfsigned"
+	"github.com/deployport/airtls/store"
 	redis "github.com/redis/go-redis/v9"
 )
 
+func TestRedisCache(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(
+		selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256),
+		selfsigned.WithValidity(24*time.Hour),
+	).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	newCache := func(t *testing.T, opts ...cachingredis.RedisCacheOption) (*miniredis.Miniredis, *cachingredis.RedisCache) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return server, cachingredis.New(client, opts...)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		_, cache := newCache(t)
+		if _, err := cache.GetCertificate("example.com"); !store.IsCertificateNotFound(err) {
+			t.Fatalf("expected certificate not found, got %v", err)
+		}
+		if err := cache.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		retrieved, err := cache.GetCertificate("example.com")
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if !retrieved.Leaf.Equal(cert.Leaf) {
+			t.Error("expected retrieved certificate to match stored certificate")
+		}
+	})
+	t.Run("TTL", func(t *testing.T) {
+		server, cache := newCache(t, cachingredis.WithExpiryMargin(time.Hour))
+		if err := cache.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if ttl := server.TTL("airtls:example.com"); ttl <= 22*time.Hour || ttl > 23*time.Hour {
+			t.Errorf("expected TTL to end an hour before NotAfter, got %v", ttl)
+		}
+		server.FastForward(23 * time.Hour)
+		if _, err := cache.GetCertificate("example.com"); !store.IsCertificateNotFound(err) {
+			t.Errorf("expected entry to expire with its certificate, got %v", err)
+		}
+	})
+	t.Run("max TTL", func(t *testing.T) {
+		server, cache := newCache(t, cachingredis.WithMaxTTL(time.Hour))
+		if err := cache.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if ttl := server.TTL("airtls:example.com"); ttl != time.Hour {
+			t.Errorf("expected TTL capped to an hour, got %v", ttl)
+		}
+	})
+	t.Run("margin longer than lifetime", func(t *testing.T) {
+		server, cache := newCache(t, cachingredis.WithExpiryMargin(48*time.Hour))
+		if err := cache.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		// capped to a quarter of the 24 hour lifetime
+		if ttl := server.TTL("airtls:example.com"); ttl <= 17*time.Hour || ttl > 18*time.Hour {
+			t.Errorf("expected TTL to end 6 hours before NotAfter, got %v", ttl)
+		}
+		if _, err := cache.GetCertificate("example.com"); err != nil {
+			t.Errorf("GetCertificate failed: %v", err)
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		// a long-lived certificate an hour away from its NotAfter
+		soon := newTestCertificate(t, "example.com", time.Now().Add(-30*24*time.Hour), time.Now().Add(time.Hour))
+		server, cache := newCache(t)
+		if err := cache.SetCertificate("example.com", *soon); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		defer client.Close()
+		// an entry written without TTL, as by earlier versions
+		if err := client.Persist(t.Context(), "airtls:example.com").Err(); err != nil {
+			t.Fatalf("PERSIST failed: %v", err)
+		}
+
+		// the margin makes the stored certificate expired from the reader's point of view
+		expiring := cachingredis.New(client, cachingredis.WithExpiryMargin(2*time.Hour))
+		if _, err := expiring.GetCertificate("example.com"); !store.IsCertificateNotFound(err) {
+			t.Errorf("expected expired entry to be not found, got %v", err)
+		}
+		if err := expiring.SetCertificate("example.com", *soon); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if server.Exists("airtls:example.com") {
+			t.Error("expected expired certificate to replace the entry by deleting it")
+		}
+	})
+	t.Run("delete list stat", func(t *testing.T) {
+		server, cache := newCache(t)
+		for _, name := range []string{"a.example.com", "b.example.com"} {
+			if err := cache.SetCertificate(name, *cert); err != nil {
+				t.Fatalf("SetCertificate failed: %v", err)
+			}
+		}
+		// locks of a Locker sharing the client must not be listed
+		locker := cachingredis.NewLocker(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+		unlock, err := locker.Lock(t.Context(), "c.example.com")
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		defer unlock()
+
+		info, err := cache.StatCertificate(t.Context(), "a.example.com")
+		if err != nil {
+			t.Fatalf("StatCertificate failed: %v", err)
+		}
+		if !info.NotAfter.Equal(cert.Leaf.NotAfter) {
+			t.Errorf("unexpected certificate info %+v", info)
+		}
+		if err := cache.DeleteCertificate(t.Context(), "a.example.com"); err != nil {
+			t.Fatalf("DeleteCertificate failed: %v", err)
+		}
+		names, err := cache.ListCertificates(t.Context())
+		if err != nil {
+			t.Fatalf("ListCertificates failed: %v", err)
+		}
+		if !slices.Equal(names, []string{"b.example.com"}) {
+			t.Errorf("expected only b.example.com to be listed, got %v", names)
+		}
+	})
+}
+
+func TestRedisCacheUniversalClient(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	names := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"}
+	clients := map[string]func(t *testing.T) redis.UniversalClient{
+		"cluster": func(t *testing.T) redis.UniversalClient {
+			return redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{miniredis.RunT(t).Addr()}})
+		},
+		"ring": func(t *testing.T) redis.UniversalClient {
+			return redis.NewRing(&redis.RingOptions{Addrs: map[string]string{
+				"shard1": miniredis.RunT(t).Addr(),
+				"shard2": miniredis.RunT(t).Addr(),
+			}})
+		},
+		"universal": func(t *testing.T) redis.UniversalClient {
+			return redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{miniredis.RunT(t).Addr()}})
+		},
+	}
+	for name, newClient := range clients {
+		t.Run(name, func(t *testing.T) {
+			client := newClient(t)
+			defer client.Close()
+			cache := cachingredis.New(client)
+			for _, name := range names {
+				if err := cache.SetCertificate(name, *cert); err != nil {
+					t.Fatalf("SetCertificate failed: %v", err)
+				}
+			}
+			if _, err := cache.GetCertificate("b.example.com"); err != nil {
+				t.Fatalf("GetCertificate failed: %v", err)
+			}
+			listed, err := cache.ListCertificates(t.Context())
+			if err != nil {
+				t.Fatalf("ListCertificates failed: %v", err)
+			}
+			slices.Sort(listed)
+			if !slices.Equal(listed, names) {
+				t.Errorf("expected every node to be scanned, got %v", listed)
+			}
+		})
+	}
+}
+
+func newTestCertificate(t *testing.T, serverName string, notBefore, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv, Leaf: leaf}
+}
+
 // ExampleRedisCache demonstrates how to use RedisCache with JSON encoding for certificate storage.
 func ExampleRedisCache() {
 	ctx := context.Background()
@@ -37,3 +245,33 @@ func ExampleRedisCache() {
 		panic(err) // Handle error appropriately in production code
 	}
 }
+
+// ExampleNewLocker demonstrates how a fleet of replicas sharing a RedisCache agrees on one certificate per name.
+func ExampleNewLocker() {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   0,
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello, HTTPS from one of many replicas!"))
+	})
+
+	err := https.ServeHTTPS(
+		ctx,
+		selfsigned.NewGenerator(),
+		cachingredis.New(client),
+		":443",
+		handler,
+		https.WithGetCertificateOptions(
+			https.WithLocker(
+				cachingredis.NewLocker(client, cachingredis.WithLockWait(5*time.Second)),
+				https.LockFallbackGenerate,
+			),
+		),
+	)
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+}
@@ -0,0 +1,111 @@
+package cachingredis
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/deployport/airtls/certencoding"
+	"github.com/deployport/airtls/store"
+)
+
+// FormatJSON is the format tag of certencoding/json entries, the default encoding.
+const FormatJSON = "json"
+
+// formatMarker starts the format tag of stored values, which is terminated by a newline.
+// JSON values are stored untagged, as objects starting with '{', so replicas predating tags can read them.
+const formatMarker = '@'
+
+// WithCodec sets the encoding of stored certificates. Values are tagged with format, except for FormatJSON,
+// so entries written in another format stay readable as long as a decoder for it is registered.
+// The format must not contain newlines. Replicas predating format tags only read FormatJSON values,
+// switch to another format once every replica is upgraded.
+func WithCodec(format string, codec certencoding.Codec) RedisCacheOption {
+	return func(c *RedisCache) {
+		c.format = format
+		c.codec = codec
+		c.decoders[format] = codec
+	}
+}
+
+// WithDecoder registers an unmarshaler for values tagged with format, to read entries written
+// by an earlier configuration or another deployment. JSON is always readable.
+func WithDecoder(format string, unmarshaler certencoding.Unmarshaler) RedisCacheOption {
+	return func(c *RedisCache) {
+		c.decoders[format] = unmarshaler
+	}
+}
+
+// encode returns the value stored for cert, tagged with its format unless JSON
+func (c *RedisCache) encode(cert tls.Certificate) ([]byte, error) {
+	if c.format == "" || strings.ContainsRune(c.format, '\n') {
+		return nil, fmt.Errorf("invalid certificate format %q", c.format)
+	}
+	var buf bytes.Buffer
+	if c.format != FormatJSON {
+		buf.WriteByte(formatMarker)
+		buf.WriteString(c.format)
+		buf.WriteByte('\n')
+	}
+	if err := c.codec.Marshal(cert, &buf); err != nil {
+		return nil, fmt.Errorf("%s marshal error: %w", c.format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decode parses a stored value
+func (c *RedisCache) decode(val []byte) (*tls.Certificate, error) {
+	format, unmarshaler, payload, err := c.decoder(val)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := unmarshaler.Unmarshal(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("%s unmarshal error: %w", format, err)
+	}
+	return cert, nil
+}
+
+// decodeLeaf parses the leaf certificate of a stored value,
+// without the private key when the decoder implements certencoding.LeafUnmarshaler
+func (c *RedisCache) decodeLeaf(val []byte) (*x509.Certificate, error) {
+	format, unmarshaler, payload, err := c.decoder(val)
+	if err != nil {
+		return nil, err
+	}
+	var leaf *x509.Certificate
+	if leafUnmarshaler, ok := unmarshaler.(certencoding.LeafUnmarshaler); ok {
+		leaf, err = leafUnmarshaler.UnmarshalLeaf(bytes.NewReader(payload))
+	} else {
+		var cert *tls.Certificate
+		if cert, err = unmarshaler.Unmarshal(bytes.NewReader(payload)); err == nil {
+			leaf, err = store.CertificateLeaf(cert)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s unmarshal error: %w", format, err)
+	}
+	return leaf, nil
+}
+
+// decoder returns the format, its unmarshaler and the payload of a stored value
+func (c *RedisCache) decoder(val []byte) (string, certencoding.Unmarshaler, []byte, error) {
+	if len(val) > 0 && val[0] == '{' {
+		return FormatJSON, c.decoders[FormatJSON], val, nil
+	}
+	if len(val) == 0 || val[0] != formatMarker {
+		return "", nil, nil, errors.New("stored certificate has no format tag")
+	}
+	format, payload, ok := bytes.Cut(val[1:], []byte{'\n'})
+	if !ok {
+		return "", nil, nil, errors.New("stored certificate format tag is truncated")
+	}
+	unmarshaler, ok := c.decoders[string(format)]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("no decoder registered for certificate format %q", format)
+	}
+	return string(format), unmarshaler, payload, nil
+}
@@ -0,0 +1,73 @@
+package cachingredis_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/deployport/airtls/caching/cachingredis"
+	"github.com/deployport/airtls/certencoding/encrypted"
+	"github.com/deployport/airtls/certencoding/json"
+	"github.com/deployport/airtls/selfsigned"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func TestRedisCacheFormats(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	// an entry written before values were tagged
+	var legacy bytes.Buffer
+	if err := (&json.Marshaler{}).Marshal(*cert, &legacy); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if err := server.Set("airtls:legacy.example.com", legacy.String()); err != nil {
+		t.Fatalf("failed to write legacy entry: %v", err)
+	}
+	jsonCache := cachingredis.New(client)
+	if err := jsonCache.SetCertificate("json.example.com", *cert); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	// JSON stays untagged so replicas predating tags read it
+	if val, _ := server.Get("airtls:json.example.com"); val != legacy.String() {
+		t.Errorf("expected untagged JSON value, got %q", val[:6])
+	}
+
+	key, err := encrypted.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	ring, err := encrypted.NewKeyRing("k1", map[string][]byte{"k1": key})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	encryptedCache := cachingredis.New(client, cachingredis.WithCodec("json+aes", encrypted.New(&json.Marshaler{}, ring)))
+	if err := encryptedCache.SetCertificate("encrypted.example.com", *cert); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	if val, _ := server.Get("airtls:encrypted.example.com"); val[:10] != "@json+aes\n" {
+		t.Errorf("expected value tagged with its format, got %q", val[:10])
+	}
+
+	// the switched deployment reads every format
+	for _, name := range []string{"legacy.example.com", "json.example.com", "encrypted.example.com"} {
+		retrieved, err := encryptedCache.GetCertificate(name)
+		if err != nil {
+			t.Fatalf("GetCertificate(%s) failed: %v", name, err)
+		}
+		if !retrieved.Leaf.Equal(cert.Leaf) {
+			t.Errorf("expected %s to match the stored certificate", name)
+		}
+		if _, err := encryptedCache.StatCertificate(t.Context(), name); err != nil {
+			t.Errorf("StatCertificate(%s) failed: %v", name, err)
+		}
+	}
+	if _, err := jsonCache.GetCertificate("encrypted.example.com"); err == nil {
+		t.Error("expected a format without registered decoder to fail")
+	}
+}
@@ -0,0 +1,238 @@
+package cachingredis
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/deployport/airtls/store"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// DefaultInvalidationChannel is the suggested channel for invalidation events.
+const DefaultInvalidationChannel = "airtls:invalidation"
+
+// InvalidationOp is the change an InvalidationEvent reports.
+type InvalidationOp string
+
+const (
+	// InvalidationSet reports a certificate stored or replaced.
+	InvalidationSet InvalidationOp = "set"
+	// InvalidationDelete reports a certificate deleted.
+	InvalidationDelete InvalidationOp = "delete"
+)
+
+// InvalidationEvent is published by a RedisCache when a certificate changes.
+type InvalidationEvent struct {
+	// Op is the change.
+	Op InvalidationOp `json:"op"`
+	// ServerName is the server name of the changed certificate.
+	ServerName string `json:"name"`
+	// NodeID identifies the RedisCache that made the change.
+	NodeID string `json:"node"`
+}
+
+// WithInvalidationChannel makes the cache publish an InvalidationEvent on channel for every certificate
+// it stores or deletes, so other nodes can drop their local copies with Subscribe.
+func WithInvalidationChannel(channel string) RedisCacheOption {
+	return func(c *RedisCache) {
+		c.channel = channel
+	}
+}
+
+// WithPublishErrorHandler sets a callback invoked when an invalidation event fails to publish.
+// The write itself succeeded, so SetCertificate and DeleteCertificate do not fail,
+// but other nodes keep serving their local copies until evicted otherwise.
+func WithPublishErrorHandler(handler func(err error)) RedisCacheOption {
+	return func(c *RedisCache) {
+		c.publishErrorHandler = handler
+	}
+}
+
+// WithNodeID sets the identifier carried by published events, random by default.
+// Subscribe ignores the events of its own node, whose local tiers are already up to date.
+func WithNodeID(id string) RedisCacheOption {
+	return func(c *RedisCache) {
+		c.nodeID = id
+	}
+}
+
+// SubscribeOption configures RedisCache.Subscribe.
+type SubscribeOption func(*SubscribeConfig)
+
+// SubscribeConfig holds configuration for RedisCache.Subscribe.
+type SubscribeConfig struct {
+	// Refresh reloads changed certificates from Redis into the local stores instead of evicting them.
+	Refresh bool
+	// ErrorHandler is called for errors that do not stop the subscription.
+	ErrorHandler func(err error)
+	// RetryInterval is the delay before reconnecting after a connection failure.
+	RetryInterval time.Duration
+}
+
+// WithRefresh reloads changed certificates from Redis into the local stores instead of evicting them,
+// so the next handshake does not pay the Redis round trip.
+func WithRefresh() SubscribeOption {
+	return func(cfg *SubscribeConfig) {
+		cfg.Refresh = true
+	}
+}
+
+// WithSubscribeErrorHandler sets a callback invoked for errors that do not stop the subscription,
+// such as connection failures and local stores failing to apply an event.
+func WithSubscribeErrorHandler(handler func(err error)) SubscribeOption {
+	return func(cfg *SubscribeConfig) {
+		cfg.ErrorHandler = handler
+	}
+}
+
+// WithSubscribeRetryInterval sets the delay before reconnecting after a connection failure.
+func WithSubscribeRetryInterval(interval time.Duration) SubscribeOption {
+	return func(cfg *SubscribeConfig) {
+		cfg.RetryInterval = interval
+	}
+}
+
+// Subscribe applies the invalidation events published by other nodes to locals, usually the memory tier
+// in front of this cache, until ctx is done. Changed certificates are evicted, or reloaded with WithRefresh.
+// Every local store must implement store.CertificateDeleter.
+//
+// Events published while the connection is down are lost, so after reconnecting every certificate
+// of the local stores implementing store.CertificateLister is evicted.
+func (c *RedisCache) Subscribe(ctx context.Context, locals []store.Store, opts ...SubscribeOption) error {
+	if c.channel == "" {
+		return errors.New("invalidation channel is not configured")
+	}
+	cfg := &SubscribeConfig{
+		RetryInterval: time.Second,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	for i, local := range locals {
+		if _, ok := local.(store.CertificateDeleter); !ok {
+			return fmt.Errorf("local store %d cannot delete certificates: %w", i, errors.ErrUnsupported)
+		}
+	}
+	report := func(err error) {
+		if cfg.ErrorHandler != nil {
+			cfg.ErrorHandler(err)
+		}
+	}
+
+	pubsub := c.client.Subscribe(ctx, c.channel)
+	defer pubsub.Close()
+	// Receive does not watch ctx
+	stop := context.AfterFunc(ctx, func() { pubsub.Close() })
+	defer stop()
+
+	subscribed := false
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			report(fmt.Errorf("redis subscribe error: %w", err))
+			timer := time.NewTimer(cfg.RetryInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if subscribed {
+				if err := evictAll(ctx, locals); err != nil {
+					report(err)
+				}
+			}
+			subscribed = true
+		case *redis.Message:
+			var event InvalidationEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				report(fmt.Errorf("invalid invalidation event: %w", err))
+				continue
+			}
+			if event.NodeID == c.nodeID {
+				continue
+			}
+			if err := c.apply(ctx, locals, event, cfg.Refresh); err != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// apply updates locals for an event of another node
+func (c *RedisCache) apply(ctx context.Context, locals []store.Store, event InvalidationEvent, refresh bool) error {
+	var cert *tls.Certificate
+	if refresh && event.Op == InvalidationSet {
+		var err error
+		cert, err = c.GetCertificateContext(ctx, event.ServerName)
+		if err != nil && !store.IsCertificateNotFound(err) {
+			return fmt.Errorf("failed to refresh %s: %w", event.ServerName, err)
+		}
+	}
+	var errs []error
+	for _, local := range locals {
+		var err error
+		if cert != nil {
+			err = store.NewContextStore(local).SetCertificateContext(ctx, event.ServerName, *cert)
+		} else {
+			err = local.(store.CertificateDeleter).DeleteCertificate(ctx, event.ServerName)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to invalidate %s: %w", event.ServerName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// evictAll deletes every certificate of the local stores that can list them
+func evictAll(ctx context.Context, locals []store.Store) error {
+	var errs []error
+	for _, local := range locals {
+		lister, ok := local.(store.CertificateLister)
+		if !ok {
+			continue
+		}
+		names, err := lister.ListCertificates(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list local certificates: %w", err))
+			continue
+		}
+		for _, name := range names {
+			if err := local.(store.CertificateDeleter).DeleteCertificate(ctx, name); err != nil {
+				errs = append(errs, fmt.Errorf("failed to invalidate %s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publish announces a change when an invalidation channel is configured.
+// Failures are reported to the publish error handler, the change itself is already stored.
+func (c *RedisCache) publish(ctx context.Context, op InvalidationOp, serverName string) {
+	if c.channel == "" {
+		return
+	}
+	payload, err := json.Marshal(InvalidationEvent{Op: op, ServerName: serverName, NodeID: c.nodeID})
+	if err == nil {
+		err = c.client.Publish(ctx, c.channel, payload).Err()
+	}
+	if err != nil && c.publishErrorHandler != nil {
+		c.publishErrorHandler(fmt.Errorf("failed to publish %s invalidation for %s: %w", op, serverName, err))
+	}
+}
+
+func newNodeID() string {
+	return rand.Text()
+}
@@ -0,0 +1,191 @@
+package cachingredis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/caching/cachingredis"
+	"github.com/deployport/airtls/selfsigned"
+	"github.com/deployport/airtls/store"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func TestSubscribe(t *testing.T) {
+	generator := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+	oldCert, err := generator.Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	newCert, err := generator.Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+
+	// node starts a memory tier over a RedisCache subscribed to the invalidation channel
+	node := func(t *testing.T, server *miniredis.Miniredis, opts ...cachingredis.SubscribeOption) (*caching.MemoryStore, *cachingredis.RedisCache) {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		cache := cachingredis.New(client, cachingredis.WithInvalidationChannel(cachingredis.DefaultInvalidationChannel))
+		memory := caching.NewMemoryStore()
+		ctx, cancel := context.WithCancel(t.Context())
+		done := make(chan error, 1)
+		go func() {
+			done <- cache.Subscribe(ctx, []store.Store{memory}, opts...)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("Subscribe failed: %v", err)
+			}
+		})
+		return memory, cache
+	}
+	waitSubscribers := func(t *testing.T, server *miniredis.Miniredis, n int) {
+		for server.PubSubNumSub(cachingredis.DefaultInvalidationChannel)[cachingredis.DefaultInvalidationChannel] < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor := func(t *testing.T, cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the event to be applied")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("evict", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		memoryA, cacheA := node(t, server)
+		memoryB, _ := node(t, server)
+		waitSubscribers(t, server, 2)
+		for _, memory := range []*caching.MemoryStore{memoryA, memoryB} {
+			if err := memory.SetCertificate("example.com", *oldCert); err != nil {
+				t.Fatalf("SetCertificate failed: %v", err)
+			}
+		}
+
+		// node A renews, its own memory tier is written by its TieredStore
+		if err := memoryA.SetCertificate("example.com", *newCert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if err := cacheA.SetCertificate("example.com", *newCert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		waitFor(t, func() bool {
+			_, err := memoryB.StatCertificate(t.Context(), "example.com")
+			return store.IsCertificateNotFound(err)
+		})
+		if _, err := memoryA.StatCertificate(t.Context(), "example.com"); err != nil {
+			t.Errorf("expected events of the node itself to be ignored, got %v", err)
+		}
+	})
+	t.Run("refresh", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		_, cacheA := node(t, server)
+		memoryB, _ := node(t, server, cachingredis.WithRefresh())
+		waitSubscribers(t, server, 2)
+		if err := memoryB.SetCertificate("example.com", *oldCert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+
+		if err := cacheA.SetCertificate("example.com", *newCert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		waitFor(t, func() bool {
+			info, err := memoryB.StatCertificate(t.Context(), "example.com")
+			return err == nil && info.SerialNumber.Cmp(newCert.Leaf.SerialNumber) == 0
+		})
+
+		if err := cacheA.DeleteCertificate(t.Context(), "example.com"); err != nil {
+			t.Fatalf("DeleteCertificate failed: %v", err)
+		}
+		waitFor(t, func() bool {
+			_, err := memoryB.StatCertificate(t.Context(), "example.com")
+			return store.IsCertificateNotFound(err)
+		})
+	})
+}
+
+func TestPublishFailure(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	client.AddHook(failPublishHook{})
+	var reported []error
+	cache := cachingredis.New(client,
+		cachingredis.WithInvalidationChannel(cachingredis.DefaultInvalidationChannel),
+		cachingredis.WithPublishErrorHandler(func(err error) {
+			reported = append(reported, err)
+		}),
+	)
+
+	if err := cache.SetCertificate("example.com", *cert); err != nil {
+		t.Fatalf("expected SetCertificate to succeed without publishing, got %v", err)
+	}
+	if !server.Exists("airtls:example.com") {
+		t.Error("expected certificate to be stored")
+	}
+	if err := cache.DeleteCertificate(t.Context(), "example.com"); err != nil {
+		t.Fatalf("expected DeleteCertificate to succeed without publishing, got %v", err)
+	}
+	if server.Exists("airtls:example.com") {
+		t.Error("expected certificate to be deleted")
+	}
+	if len(reported) != 2 || !errors.Is(reported[0], errPublish) {
+		t.Errorf("expected both publish failures to be reported, got %v", reported)
+	}
+}
+
+var errPublish = errors.New("publish failed")
+
+// failPublishHook fails every PUBLISH command
+type failPublishHook struct{}
+
+func (failPublishHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (failPublishHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "publish" {
+			cmd.SetErr(errPublish)
+			return errPublish
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (failPublishHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// ExampleRedisCache_Subscribe demonstrates how nodes keep their memory tier in sync when another node renews a certificate.
+func ExampleRedisCache_Subscribe() {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	cache := cachingredis.New(client, cachingredis.WithInvalidationChannel(cachingredis.DefaultInvalidationChannel))
+	memory := caching.NewMemoryStore()
+
+	go func() {
+		err := cache.Subscribe(ctx, []store.Store{memory}, cachingredis.WithRefresh())
+		if err != nil {
+			panic(err) // Handle error appropriately in production code
+		}
+	}()
+
+	// serve with the tiered store, e.g. with https.ServeHTTPS
+	_ = caching.NewTieredStoreWithOptions(
+		[]store.Store{memory, cache},
+		caching.WithBackfill(caching.BackfillSync),
+	)
+}
@@ -0,0 +1,131 @@
+package cachingredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// ErrLockNotAcquired is returned by Locker.Lock when the lock is still held by another process
+// after waiting for it.
+var ErrLockNotAcquired = errors.New("redis lock not acquired")
+
+// unlockScript deletes the lock only if it still holds our token,
+// so an expired lease taken over by another process is never released by mistake.
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// Locker implements store.Locker with Redis so a fleet sharing a RedisCache generates
+// a single certificate per server name.
+// Locks are taken with SET NX and a lease, and hold a random token so only the owner releases them.
+// Each lock is a single key, so it works with any redis.UniversalClient including Cluster.
+type Locker struct {
+	client        redis.UniversalClient
+	prefix        string
+	lease         time.Duration
+	wait          time.Duration
+	retryInterval time.Duration
+}
+
+// LockerOption configures a Locker.
+type LockerOption func(*Locker)
+
+// WithLockPrefix sets the prefix for lock keys in Redis.
+func WithLockPrefix(prefix string) LockerOption {
+	return func(l *Locker) {
+		l.prefix = prefix
+	}
+}
+
+// WithLockLease sets how long a lock is held before it expires on its own.
+// It must be longer than certificate generation takes, it protects against crashed holders.
+func WithLockLease(lease time.Duration) LockerOption {
+	return func(l *Locker) {
+		l.lease = lease
+	}
+}
+
+// WithLockWait sets how long Lock waits for a lock held by another process before giving up
+// with ErrLockNotAcquired.
+func WithLockWait(wait time.Duration) LockerOption {
+	return func(l *Locker) {
+		l.wait = wait
+	}
+}
+
+// WithLockRetryInterval sets how often Lock retries while waiting for a lock.
+func WithLockRetryInterval(interval time.Duration) LockerOption {
+	return func(l *Locker) {
+		l.retryInterval = interval
+	}
+}
+
+// NewLocker creates a new Locker with the given Redis client and options.
+func NewLocker(client redis.UniversalClient, opts ...LockerOption) *Locker {
+	locker := &Locker{
+		client: client,
+		// kept outside the cache prefix "airtls:" so RedisCache.ListCertificates does not list locks
+		prefix:        "airtls-lock:",
+		lease:         time.Minute,
+		wait:          10 * time.Second,
+		retryInterval: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(locker)
+	}
+	return locker
+}
+
+// Lock acquires the lock for serverName, retrying until it is acquired, the wait elapses or ctx is done.
+func (l *Locker) Lock(ctx context.Context, serverName string) (func(), error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	key := l.prefix + serverName
+	deadline := time.Now().Add(l.wait)
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, l.lease).Result()
+		if err != nil {
+			return nil, fmt.Errorf("redis lock error: %w", err)
+		}
+		if ok {
+			return func() { l.unlock(key, token) }, nil
+		}
+		if !time.Now().Add(l.retryInterval).Before(deadline) {
+			return nil, ErrLockNotAcquired
+		}
+		timer := time.NewTimer(l.retryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *Locker) unlock(key, token string) {
+	// the caller's context may be done already, the lock must still be released
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// on failure the lease expires the lock
+	_ = unlockScript.Run(ctx, l.client, []string{key}, token).Err()
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,73 @@
+package cachingredis_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/deployport/airtls/caching/cachingredis"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func TestLocker(t *testing.T) {
+	newLockers := func(t *testing.T, opts ...cachingredis.LockerOption) (*miniredis.Miniredis, *cachingredis.Locker, *cachingredis.Locker) {
+		server := miniredis.RunT(t)
+		// separate clients behave like separate replicas
+		first := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		second := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() {
+			first.Close()
+			second.Close()
+		})
+		opts = append([]cachingredis.LockerOption{
+			cachingredis.WithLockWait(50 * time.Millisecond),
+			cachingredis.WithLockRetryInterval(5 * time.Millisecond),
+		}, opts...)
+		return server, cachingredis.NewLocker(first, opts...), cachingredis.NewLocker(second, opts...)
+	}
+
+	t.Run("mutual exclusion", func(t *testing.T) {
+		_, first, second := newLockers(t)
+		unlock, err := first.Lock(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		if _, err := second.Lock(t.Context(), "example.com"); !errors.Is(err, cachingredis.ErrLockNotAcquired) {
+			t.Fatalf("expected lock held by another replica not to be acquired, got %v", err)
+		}
+		unlockOther, err := second.Lock(t.Context(), "other.example.com")
+		if err != nil {
+			t.Fatalf("expected locks of other names to be independent, got %v", err)
+		}
+		unlockOther()
+		unlock()
+		unlock, err = second.Lock(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("Lock after release failed: %v", err)
+		}
+		unlock()
+	})
+	t.Run("lease", func(t *testing.T) {
+		server, first, second := newLockers(t, cachingredis.WithLockLease(time.Second))
+		staleUnlock, err := first.Lock(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		// the holder crashed, its lease expires
+		server.FastForward(2 * time.Second)
+		unlock, err := second.Lock(t.Context(), "example.com")
+		if err != nil {
+			t.Fatalf("expected expired lease to be taken over, got %v", err)
+		}
+		// releasing the expired lock must not release the new holder's lock
+		staleUnlock()
+		if !server.Exists("airtls-lock:example.com") {
+			t.Error("expected the stale holder not to release the lock taken over")
+		}
+		unlock()
+		if server.Exists("airtls-lock:example.com") {
+			t.Error("expected the owner to release the lock")
+		}
+	})
+}
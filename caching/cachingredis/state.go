@@ -0,0 +1,69 @@
+package cachingredis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/deployport/airtls/acmeissuer"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// StateStore implements acmeissuer.StateStore with Redis, so replicas sharing it use a single
+// ACME account and resume each other's in-progress orders.
+// The state includes the account private key, so access to the Redis server must be restricted.
+// Each state is a single key without TTL, so it works with any redis.UniversalClient including Cluster.
+type StateStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// StateStoreOption configures a StateStore.
+type StateStoreOption func(*StateStore)
+
+// WithStatePrefix sets the prefix for state keys in Redis.
+// It must differ from the RedisCache prefix, which lists every key under it as a certificate.
+func WithStatePrefix(prefix string) StateStoreOption {
+	return func(s *StateStore) {
+		s.prefix = prefix
+	}
+}
+
+// NewStateStore creates a new StateStore with the given Redis client and options.
+func NewStateStore(client redis.UniversalClient, opts ...StateStoreOption) *StateStore {
+	s := &StateStore{
+		client: client,
+		prefix: "airtls-acme:",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// GetState returns the data stored under key, or acmeissuer.ErrStateNotFound.
+func (s *StateStore) GetState(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, acmeissuer.ErrStateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis get error: %w", err)
+	}
+	return data, nil
+}
+
+// SetState stores data under key.
+func (s *StateStore) SetState(ctx context.Context, key string, data []byte) error {
+	if err := s.client.Set(ctx, s.prefix+key, data, 0).Err(); err != nil {
+		return fmt.Errorf("redis set error: %w", err)
+	}
+	return nil
+}
+
+// DeleteState removes the data stored under key.
+func (s *StateStore) DeleteState(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return fmt.Errorf("redis del error: %w", err)
+	}
+	return nil
+}
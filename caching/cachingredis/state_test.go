@@ -0,0 +1,124 @@
+package cachingredis_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/deployport/airtls/acmeissuer"
+	"github.com/deployport/airtls/caching/cachingredis"
+	"github.com/deployport/airtls/https"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func TestStateStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	// separate clients behave like separate replicas
+	first := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	second := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		first.Close()
+		second.Close()
+	})
+	var state acmeissuer.StateStore = cachingredis.NewStateStore(first)
+	shared := cachingredis.NewStateStore(second)
+
+	if _, err := state.GetState(t.Context(), "account.key"); !errors.Is(err, acmeissuer.ErrStateNotFound) {
+		t.Fatalf("expected ErrStateNotFound, got %v", err)
+	}
+	if err := state.SetState(t.Context(), "account.key", []byte("key")); err != nil {
+		t.Fatalf("SetState failed: %v", err)
+	}
+	data, err := shared.GetState(t.Context(), "account.key")
+	if err != nil {
+		t.Fatalf("GetState failed: %v", err)
+	}
+	if !bytes.Equal(data, []byte("key")) {
+		t.Errorf("expected the state set by another replica, got %q", data)
+	}
+	if ttl := server.TTL("airtls-acme:account.key"); ttl != 0 {
+		t.Errorf("expected state without TTL, got %v", ttl)
+	}
+
+	// state is kept apart from cached certificates
+	names, err := cachingredis.New(first).ListCertificates(t.Context())
+	if err != nil {
+		t.Fatalf("ListCertificates failed: %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("expected state not to be listed as certificates, got %v", names)
+	}
+
+	if err := shared.DeleteState(t.Context(), "account.key"); err != nil {
+		t.Fatalf("DeleteState failed: %v", err)
+	}
+	if _, err := state.GetState(t.Context(), "account.key"); !errors.Is(err, acmeissuer.ErrStateNotFound) {
+		t.Fatalf("expected ErrStateNotFound after delete, got %v", err)
+	}
+	if err := state.DeleteState(t.Context(), "account.key"); err != nil {
+		t.Errorf("expected deleting missing state to succeed, got %v", err)
+	}
+
+	prefixed := cachingredis.NewStateStore(first, cachingredis.WithStatePrefix("acme:"))
+	if err := prefixed.SetState(t.Context(), "order+example.com", []byte("order")); err != nil {
+		t.Fatalf("SetState failed: %v", err)
+	}
+	if !server.Exists("acme:order+example.com") {
+		t.Error("expected state under the configured prefix")
+	}
+}
+
+func TestStateStoreUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	state := cachingredis.NewStateStore(client)
+	server.Close()
+
+	if _, err := state.GetState(t.Context(), "account.key"); err == nil || errors.Is(err, acmeissuer.ErrStateNotFound) {
+		t.Errorf("expected a Redis error, got %v", err)
+	}
+	if err := state.SetState(t.Context(), "account.key", []byte("key")); err == nil {
+		t.Error("expected SetState to fail")
+	}
+	if err := state.DeleteState(t.Context(), "account.key"); err == nil {
+		t.Error("expected DeleteState to fail")
+	}
+}
+
+// ExampleNewStateStore demonstrates how replicas share one ACME account and their in-progress orders.
+func ExampleNewStateStore() {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   0,
+	})
+
+	solver := acmeissuer.NewTLSALPN01Solver()
+	generator, err := acmeissuer.New(
+		acmeissuer.WithContact("mailto:admin@example.com"),
+		acmeissuer.WithAcceptTOS(),
+		acmeissuer.WithStateStore(cachingredis.NewStateStore(client)),
+		acmeissuer.WithSolvers(solver),
+	)
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+
+	err = https.ServeHTTPS(
+		ctx,
+		generator,
+		cachingredis.New(client),
+		":443",
+		nil,
+		https.WithGetCertificateOptions(
+			https.WithChallengeProvider(solver),
+			https.WithLocker(cachingredis.NewLocker(client), https.LockFallbackGenerate),
+		),
+	)
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+}
@@ -0,0 +1,8 @@
+package caching
+
+import "time"
+
+// SetMemoryStoreClock replaces the clock of m, before it is used.
+func SetMemoryStoreClock(m *MemoryStore, now func() time.Time) {
+	m.now = now
+}
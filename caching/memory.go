@@ -1,17 +1,46 @@
 package caching
 
 import (
+	"container/list"
+	"context"
 	"crypto/tls"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/deployport/airtls/store"
 )
 
 // MemoryStore is a concurrent in-memory implementation of Store
 // that stores certificates by server name.
+//
+// The store holds at most Capacity certificates, evicting the least recently used one when full.
+// Entries are also evicted once their certificate expires or, when configured, once their TTL elapses.
 type MemoryStore struct {
-	mu    sync.RWMutex
-	certs map[string]*tls.Certificate
+	mu      sync.Mutex
+	cfg     MemoryStoreConfig
+	entries map[string]*list.Element
+	// lru keeps the most recently used entry at the front
+	lru *list.List
+	// now is the clock expiry is checked against, replaced in tests
+	now func() time.Time
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// memoryEntry is the value of the lru list elements
+type memoryEntry struct {
+	serverName string
+	cert       *tls.Certificate
+	// expiresAt is the time the entry is evicted, zero if it never expires
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
 }
 
 // DefaultMemoryStoreCapacity is the default capacity for the MemoryStore.
@@ -22,7 +51,10 @@ type MemoryStoreOption func(*MemoryStoreConfig)
 
 // MemoryStoreConfig holds configuration for MemoryStore.
 type MemoryStoreConfig struct {
+	// Capacity is the maximum number of certificates held by the store.
 	Capacity int
+	// TTL is how long an entry is kept after being stored, zero keeps entries until their certificate expires.
+	TTL time.Duration
 }
 
 // WithCapacity sets the capacity for the MemoryStore.
@@ -32,6 +64,26 @@ func WithCapacity(capacity int) MemoryStoreOption {
 	}
 }
 
+// WithTTL sets how long an entry is kept after being stored.
+// Entries are still evicted earlier if their certificate expires first.
+func WithTTL(ttl time.Duration) MemoryStoreOption {
+	return func(cfg *MemoryStoreConfig) {
+		cfg.TTL = ttl
+	}
+}
+
+// MemoryStoreStats holds usage counters of a MemoryStore.
+type MemoryStoreStats struct {
+	// Hits is the number of lookups that found a certificate.
+	Hits uint64
+	// Misses is the number of lookups that did not find a certificate, including expired entries.
+	Misses uint64
+	// Evictions is the number of entries removed because of capacity, TTL or certificate expiry.
+	Evictions uint64
+	// Len is the number of entries currently held.
+	Len int
+}
+
 // NewMemoryStore creates a new MemoryStore instance with options.
 func NewMemoryStore(opts ...MemoryStoreOption) *MemoryStore {
 	cfg := &MemoryStoreConfig{
@@ -44,25 +96,159 @@ func NewMemoryStore(opts ...MemoryStoreOption) *MemoryStore {
 		cfg.Capacity = DefaultMemoryStoreCapacity
 	}
 	return &MemoryStore{
-		certs: make(map[string]*tls.Certificate, cfg.Capacity),
+		cfg:     *cfg,
+		entries: make(map[string]*list.Element, cfg.Capacity),
+		lru:     list.New(),
+		now:     time.Now,
 	}
 }
 
 // GetCertificate retrieves a certificate by server name.
 func (m *MemoryStore) GetCertificate(serverName string) (*tls.Certificate, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	cert, ok := m.certs[serverName]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[serverName]
 	if !ok {
+		m.misses.Add(1)
+		return nil, store.NewCertificateNotFoundError()
+	}
+	entry := el.Value.(*memoryEntry)
+	if entry.expired(m.now()) {
+		m.removeElement(el)
+		m.evictions.Add(1)
+		m.misses.Add(1)
 		return nil, store.NewCertificateNotFoundError()
 	}
-	return cert, nil
+	m.lru.MoveToFront(el)
+	m.hits.Add(1)
+	return entry.cert, nil
 }
 
-// SetCertificate stores a certificate by server name.
+// SetCertificate stores a certificate by server name, evicting the least recently used entry when full.
 func (m *MemoryStore) SetCertificate(serverName string, cert tls.Certificate) error {
+	entry := &memoryEntry{
+		serverName: serverName,
+		cert:       &cert,
+		expiresAt:  m.expiresAt(&cert),
+	}
 	m.mu.Lock()
-	m.certs[serverName] = &cert
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[serverName]; ok {
+		el.Value = entry
+		m.lru.MoveToFront(el)
+		return nil
+	}
+	for m.lru.Len() >= m.cfg.Capacity {
+		m.removeElement(m.lru.Back())
+		m.evictions.Add(1)
+	}
+	m.entries[serverName] = m.lru.PushFront(entry)
 	return nil
 }
+
+// GetCertificateContext retrieves a certificate by server name.
+// The lookup never blocks, so ctx is only checked before reading.
+func (m *MemoryStore) GetCertificateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.GetCertificate(serverName)
+}
+
+// SetCertificateContext stores a certificate by server name.
+// The write never blocks, so ctx is only checked before writing.
+func (m *MemoryStore) SetCertificateContext(ctx context.Context, serverName string, cert tls.Certificate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.SetCertificate(serverName, cert)
+}
+
+// DeleteCertificate removes the certificate of serverName from the store.
+// Deleted entries are not counted as evictions.
+func (m *MemoryStore) DeleteCertificate(ctx context.Context, serverName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[serverName]; ok {
+		m.removeElement(el)
+	}
+	return nil
+}
+
+// ListCertificates returns the server names of the entries that have not expired,
+// most recently used first. Listing does not change the eviction order.
+func (m *MemoryStore) ListCertificates(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, m.lru.Len())
+	for el := m.lru.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*memoryEntry)
+		if entry.expired(now) {
+			continue
+		}
+		names = append(names, entry.serverName)
+	}
+	return names, nil
+}
+
+// StatCertificate returns metadata about the certificate of serverName.
+// Unlike GetCertificate it neither counts as a hit nor changes the eviction order.
+func (m *MemoryStore) StatCertificate(ctx context.Context, serverName string) (*store.CertificateInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	el, ok := m.entries[serverName]
+	var entry *memoryEntry
+	if ok {
+		entry = el.Value.(*memoryEntry)
+	}
+	m.mu.Unlock()
+	if entry == nil || entry.expired(m.now()) {
+		return nil, store.NewCertificateNotFoundError()
+	}
+	leaf, err := store.CertificateLeaf(entry.cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate of %s: %w", serverName, err)
+	}
+	return store.NewCertificateInfo(serverName, leaf), nil
+}
+
+// Stats returns the usage counters of the store.
+func (m *MemoryStore) Stats() MemoryStoreStats {
+	m.mu.Lock()
+	n := m.lru.Len()
+	m.mu.Unlock()
+	return MemoryStoreStats{
+		Hits:      m.hits.Load(),
+		Misses:    m.misses.Load(),
+		Evictions: m.evictions.Load(),
+		Len:       n,
+	}
+}
+
+// expiresAt returns the earliest of the TTL deadline and the certificate expiry, zero if neither applies.
+func (m *MemoryStore) expiresAt(cert *tls.Certificate) time.Time {
+	var expiresAt time.Time
+	if m.cfg.TTL > 0 {
+		expiresAt = m.now().Add(m.cfg.TTL)
+	}
+	if leaf, err := store.CertificateLeaf(cert); err == nil {
+		if expiresAt.IsZero() || leaf.NotAfter.Before(expiresAt) {
+			expiresAt = leaf.NotAfter
+		}
+	}
+	return expiresAt
+}
+
+func (m *MemoryStore) removeElement(el *list.Element) {
+	entry := m.lru.Remove(el).(*memoryEntry)
+	delete(m.entries, entry.serverName)
+}
@@ -0,0 +1,134 @@
+package caching_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/selfsigned"
+	"github.com/deployport/airtls/store"
+)
+
+func TestMemoryStore(t *testing.T) {
+	generator := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+	cert, err := generator.Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+
+	t.Run("LRU eviction", func(t *testing.T) {
+		memoryStore := caching.NewMemoryStore(caching.WithCapacity(2))
+		for _, name := range []string{"a.example.com", "b.example.com"} {
+			if err := memoryStore.SetCertificate(name, *cert); err != nil {
+				t.Fatalf("SetCertificate failed: %v", err)
+			}
+		}
+		// touch a so b becomes the least recently used
+		if _, err := memoryStore.GetCertificate("a.example.com"); err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if err := memoryStore.SetCertificate("c.example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if _, err := memoryStore.GetCertificate("b.example.com"); !store.IsCertificateNotFound(err) {
+			t.Errorf("expected b.example.com to be evicted, got %v", err)
+		}
+		for _, name := range []string{"a.example.com", "c.example.com"} {
+			if _, err := memoryStore.GetCertificate(name); err != nil {
+				t.Errorf("expected %s to be kept, got %v", name, err)
+			}
+		}
+		stats := memoryStore.Stats()
+		if stats.Len != 2 {
+			t.Errorf("expected 2 entries, got %d", stats.Len)
+		}
+		if stats.Evictions != 1 {
+			t.Errorf("expected 1 eviction, got %d", stats.Evictions)
+		}
+		if stats.Hits != 3 || stats.Misses != 1 {
+			t.Errorf("expected 3 hits and 1 miss, got %d hits and %d misses", stats.Hits, stats.Misses)
+		}
+	})
+	t.Run("overwrite does not evict", func(t *testing.T) {
+		memoryStore := caching.NewMemoryStore(caching.WithCapacity(1))
+		for range 3 {
+			if err := memoryStore.SetCertificate("example.com", *cert); err != nil {
+				t.Fatalf("SetCertificate failed: %v", err)
+			}
+		}
+		if stats := memoryStore.Stats(); stats.Evictions != 0 || stats.Len != 1 {
+			t.Errorf("expected 1 entry and no evictions, got %+v", stats)
+		}
+	})
+	t.Run("TTL", func(t *testing.T) {
+		now := time.Now()
+		memoryStore := caching.NewMemoryStore(caching.WithTTL(time.Minute))
+		caching.SetMemoryStoreClock(memoryStore, func() time.Time { return now })
+		if err := memoryStore.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if _, err := memoryStore.GetCertificate("example.com"); err != nil {
+			t.Fatalf("GetCertificate failed before TTL: %v", err)
+		}
+		now = now.Add(time.Minute)
+		if _, err := memoryStore.GetCertificate("example.com"); !store.IsCertificateNotFound(err) {
+			t.Fatalf("expected entry to expire after TTL, got %v", err)
+		}
+		if stats := memoryStore.Stats(); stats.Evictions != 1 || stats.Len != 0 {
+			t.Errorf("expected expired entry to be evicted, got %+v", stats)
+		}
+	})
+	t.Run("certificate expiry", func(t *testing.T) {
+		shortLived, err := selfsigned.NewGenerator(
+			selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256),
+			selfsigned.WithValidity(time.Minute),
+		).Generate("example.com")
+		if err != nil {
+			t.Fatalf("failed to generate self-signed certificate: %v", err)
+		}
+		now := time.Now()
+		memoryStore := caching.NewMemoryStore(caching.WithTTL(time.Hour))
+		caching.SetMemoryStoreClock(memoryStore, func() time.Time { return now })
+		if err := memoryStore.SetCertificate("example.com", *shortLived); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		if _, err := memoryStore.GetCertificate("example.com"); err != nil {
+			t.Fatalf("GetCertificate failed before expiry: %v", err)
+		}
+		now = shortLived.Leaf.NotAfter
+		if _, err := memoryStore.GetCertificate("example.com"); !store.IsCertificateNotFound(err) {
+			t.Fatalf("expected entry to expire with its certificate, got %v", err)
+		}
+	})
+	t.Run("delete list stat", func(t *testing.T) {
+		memoryStore := caching.NewMemoryStore()
+		for _, name := range []string{"a.example.com", "b.example.com"} {
+			if err := memoryStore.SetCertificate(name, *cert); err != nil {
+				t.Fatalf("SetCertificate failed: %v", err)
+			}
+		}
+		info, err := memoryStore.StatCertificate(t.Context(), "a.example.com")
+		if err != nil {
+			t.Fatalf("StatCertificate failed: %v", err)
+		}
+		if info.ServerName != "a.example.com" || !info.NotAfter.Equal(cert.Leaf.NotAfter) {
+			t.Errorf("unexpected certificate info %+v", info)
+		}
+		if err := memoryStore.DeleteCertificate(t.Context(), "a.example.com"); err != nil {
+			t.Fatalf("DeleteCertificate failed: %v", err)
+		}
+		names, err := memoryStore.ListCertificates(t.Context())
+		if err != nil {
+			t.Fatalf("ListCertificates failed: %v", err)
+		}
+		if len(names) != 1 || names[0] != "b.example.com" {
+			t.Errorf("expected only b.example.com to be listed, got %v", names)
+		}
+		if _, err := memoryStore.StatCertificate(t.Context(), "a.example.com"); !store.IsCertificateNotFound(err) {
+			t.Errorf("expected deleted certificate to be not found, got %v", err)
+		}
+		if stats := memoryStore.Stats(); stats.Evictions != 0 || stats.Hits != 0 {
+			t.Errorf("expected stat and delete to leave counters alone, got %+v", stats)
+		}
+	})
+}
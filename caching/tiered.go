@@ -1,49 +1,437 @@
 package caching
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/deployport/airtls/store"
 )
 
 // TieredStore implements a Store that uses multiple Store implementations in order for tiered caching
 type TieredStore struct {
-	stores []store.Store
+	stores []store.ContextStore
+	// tiers are the stores as given, used to detect optional interfaces the adapters hide
+	tiers []store.Store
+	cfg   TieredStoreConfig
+	// pending tracks background writes
+	pending sync.WaitGroup
+}
+
+// BackfillMode controls whether a certificate found in a lower tier is copied into the tiers above it.
+type BackfillMode int
+
+const (
+	// BackfillNone leaves higher tiers untouched on a lower tier hit.
+	BackfillNone BackfillMode = iota
+	// BackfillSync writes higher tiers before GetCertificate returns.
+	BackfillSync
+	// BackfillAsync writes higher tiers in the background, GetCertificate returns right away.
+	BackfillAsync
+)
+
+// BackfillErrorPolicy controls how a failed backfill affects GetCertificate.
+type BackfillErrorPolicy int
+
+const (
+	// BackfillErrorIgnore returns the certificate even when a backfill fails.
+	BackfillErrorIgnore BackfillErrorPolicy = iota
+	// BackfillErrorFail makes GetCertificate return the backfill error, it only applies to BackfillSync.
+	BackfillErrorFail
+)
+
+// WriteMode controls when SetCertificate writes the tiers.
+type WriteMode int
+
+const (
+	// WriteThrough writes every tier before SetCertificate returns.
+	WriteThrough WriteMode = iota
+	// WriteBehind writes the first tier before SetCertificate returns and the other tiers in the background.
+	WriteBehind
+)
+
+// WriteErrorPolicy controls how failed tier writes affect SetCertificate.
+type WriteErrorPolicy int
+
+const (
+	// WriteAllMustSucceed fails SetCertificate when any tier written before it returns fails.
+	WriteAllMustSucceed WriteErrorPolicy = iota
+	// WriteBestEffort fails SetCertificate only when every tier written before it returns fails.
+	WriteBestEffort
+)
+
+// ReadErrorPolicy controls how a tier failing with an error other than not found affects GetCertificate.
+type ReadErrorPolicy int
+
+const (
+	// ReadErrorFail returns the error of the failing tier.
+	ReadErrorFail ReadErrorPolicy = iota
+	// ReadErrorSkip moves on to the next tier. When no tier holds the certificate the not found error
+	// is joined with the skipped tier errors.
+	ReadErrorSkip
+)
+
+// TierError reports the failure of one tier of a TieredStore.
+type TierError struct {
+	// Tier is the index of the failing store, 0 being the highest priority.
+	Tier int
+	// Op is the failing operation: "get", "set", "backfill" or "delete".
+	Op string
+	// Err is the error returned by the store.
+	Err error
+}
+
+func (e *TierError) Error() string {
+	return fmt.Sprintf("tier %d %s failed: %v", e.Tier, e.Op, e.Err)
+}
+
+// Unwrap returns the error returned by the store.
+func (e *TierError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultBackgroundTimeout is the default time limit of asynchronous backfills and write-behind writes.
+var DefaultBackgroundTimeout = 10 * time.Second
+
+// TieredStoreOption configures a TieredStore.
+type TieredStoreOption func(*TieredStoreConfig)
+
+// TieredStoreConfig holds configuration for TieredStore.
+type TieredStoreConfig struct {
+	// Backfill controls copying lower tier hits into the tiers above.
+	Backfill BackfillMode
+	// BackfillErrorPolicy controls how a failed backfill affects GetCertificate.
+	BackfillErrorPolicy BackfillErrorPolicy
+	// BackfillErrorHandler, when set, is called for every tier that fails a backfill.
+	BackfillErrorHandler func(tier int, serverName string, err error)
+	// WriteMode controls when SetCertificate writes the tiers.
+	WriteMode WriteMode
+	// WriteErrorPolicy controls how failed tier writes affect SetCertificate.
+	WriteErrorPolicy WriteErrorPolicy
+	// ParallelWrites writes the tiers concurrently instead of in order.
+	ParallelWrites bool
+	// ReadErrorPolicy controls how a failing tier affects GetCertificate.
+	ReadErrorPolicy ReadErrorPolicy
+	// ErrorHandler, when set, is called for tier errors that are not returned: skipped reads,
+	// tolerated best-effort writes and write-behind writes.
+	ErrorHandler func(err *TierError)
+	// BackgroundTimeout limits asynchronous backfills and write-behind writes,
+	// which outlive the context of the call that started them.
+	BackgroundTimeout time.Duration
+}
+
+// WithBackfill sets whether and how a certificate found in a lower tier is copied into the tiers above it,
+// so for example a memory tier warms up from Redis after a restart.
+func WithBackfill(mode BackfillMode) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.Backfill = mode
+	}
+}
+
+// WithBackfillErrorPolicy sets how a failed backfill affects GetCertificate.
+func WithBackfillErrorPolicy(policy BackfillErrorPolicy) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.BackfillErrorPolicy = policy
+	}
+}
+
+// WithBackfillErrorHandler sets a callback invoked for every tier that fails a backfill.
+func WithBackfillErrorHandler(handler func(tier int, serverName string, err error)) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.BackfillErrorHandler = handler
+	}
+}
+
+// WithWriteMode sets when SetCertificate writes the tiers.
+// WriteBehind keeps a slow shared tier such as Redis out of the handshake path.
+func WithWriteMode(mode WriteMode) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.WriteMode = mode
+	}
+}
+
+// WithWriteErrorPolicy sets how failed tier writes affect SetCertificate.
+// WriteBestEffort keeps serving a freshly generated certificate when a shared tier is down.
+func WithWriteErrorPolicy(policy WriteErrorPolicy) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.WriteErrorPolicy = policy
+	}
+}
+
+// WithParallelWrites writes the tiers concurrently, so a write takes as long as the slowest tier
+// instead of the sum of all tiers.
+func WithParallelWrites() TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.ParallelWrites = true
+	}
+}
+
+// WithReadErrorPolicy sets how a tier failing with an error other than not found affects GetCertificate.
+func WithReadErrorPolicy(policy ReadErrorPolicy) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.ReadErrorPolicy = policy
+	}
+}
+
+// WithTierErrorHandler sets a callback invoked for tier errors that are not returned to the caller.
+func WithTierErrorHandler(handler func(err *TierError)) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.ErrorHandler = handler
+	}
+}
+
+// WithBackgroundTimeout sets the time limit of asynchronous backfills and write-behind writes.
+func WithBackgroundTimeout(timeout time.Duration) TieredStoreOption {
+	return func(cfg *TieredStoreConfig) {
+		cfg.BackgroundTimeout = timeout
+	}
 }
 
 // NewTieredStore creates a new TieredStore with the given stores in order of priority, first to last where first is the highest priority
 func NewTieredStore(stores ...store.Store) *TieredStore {
-	return &TieredStore{stores: stores}
+	return NewTieredStoreWithOptions(stores)
+}
+
+// NewTieredStoreWithOptions creates a new TieredStore like NewTieredStore, configured with options.
+func NewTieredStoreWithOptions(stores []store.Store, opts ...TieredStoreOption) *TieredStore {
+	cfg := TieredStoreConfig{
+		BackgroundTimeout: DefaultBackgroundTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.BackgroundTimeout <= 0 {
+		cfg.BackgroundTimeout = DefaultBackgroundTimeout
+	}
+	ctxStores := make([]store.ContextStore, len(stores))
+	for i, s := range stores {
+		ctxStores[i] = store.NewContextStore(s)
+	}
+	return &TieredStore{stores: ctxStores, tiers: stores, cfg: cfg}
+}
+
+// Wait blocks until background writes, asynchronous backfills and write-behind writes, are done.
+func (t *TieredStore) Wait() {
+	t.pending.Wait()
 }
 
 // GetCertificate tries to retrieve a certificate from each store in order, returning the first found.
 // If none are found, returns a *store.CertificateNotFoundError.
+// A tier failing with another error fails the lookup unless configured with ReadErrorSkip.
 func (t *TieredStore) GetCertificate(serverName string) (*tls.Certificate, error) {
+	return t.GetCertificateContext(context.Background(), serverName)
+}
+
+// GetCertificateContext is like GetCertificate but passes ctx down to every tier.
+// A hit in a lower tier is backfilled into the tiers above it when configured with WithBackfill.
+func (t *TieredStore) GetCertificateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
 	var lastErr error
-	for _, s := range t.stores {
-		cert, err := s.GetCertificate(serverName)
+	var skipped []error
+	for i, s := range t.stores {
+		cert, err := s.GetCertificateContext(ctx, serverName)
 		if err == nil {
+			if err := t.backfill(ctx, i, serverName, cert); err != nil {
+				return nil, err
+			}
 			return cert, nil
 		}
-		if !store.IsCertificateNotFound(err) {
-			return nil, err
+		if store.IsCertificateNotFound(err) {
+			lastErr = err
+			continue
+		}
+		tierErr := &TierError{Tier: i, Op: "get", Err: err}
+		if t.cfg.ReadErrorPolicy != ReadErrorSkip || ctx.Err() != nil {
+			return nil, tierErr
 		}
-		lastErr = err
+		t.report(tierErr)
+		skipped = append(skipped, tierErr)
 	}
 	if lastErr == nil {
 		lastErr = store.NewCertificateNotFoundError()
 	}
+	if len(skipped) > 0 {
+		return nil, errors.Join(append([]error{lastErr}, skipped...)...)
+	}
 	return nil, lastErr
 }
 
-// SetCertificate sets the certificate in all stores in order. Returns the first error encountered, if any.
+// backfill copies cert into the tiers above hit according to the backfill configuration
+func (t *TieredStore) backfill(ctx context.Context, hit int, serverName string, cert *tls.Certificate) error {
+	if hit == 0 {
+		return nil
+	}
+	switch t.cfg.Backfill {
+	case BackfillSync:
+		err := t.writeBackfill(ctx, hit, serverName, *cert)
+		if t.cfg.BackfillErrorPolicy == BackfillErrorFail {
+			return err
+		}
+	case BackfillAsync:
+		t.pending.Add(1)
+		go func() {
+			defer t.pending.Done()
+			// the lookup context ends with the handshake, the backfill must not
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), t.cfg.BackgroundTimeout)
+			defer cancel()
+			_ = t.writeBackfill(ctx, hit, serverName, *cert)
+		}()
+	}
+	return nil
+}
+
+// writeBackfill writes cert into the tiers above hit, reporting every failed tier to the backfill error handler
+func (t *TieredStore) writeBackfill(ctx context.Context, hit int, serverName string, cert tls.Certificate) error {
+	errs := t.setTiers(ctx, 0, hit, "backfill", serverName, cert)
+	if t.cfg.BackfillErrorHandler != nil {
+		for _, err := range errs {
+			tierErr := err.(*TierError)
+			t.cfg.BackfillErrorHandler(tierErr.Tier, serverName, tierErr.Err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetCertificate sets the certificate in all stores according to the write mode and error policy.
+// By default every tier is written in order and the errors of the failed tiers are returned joined as *TierError.
 func (t *TieredStore) SetCertificate(serverName string, cert tls.Certificate) error {
-	var firstErr error
-	for _, s := range t.stores {
-		err := s.SetCertificate(serverName, cert)
-		if err != nil && firstErr == nil {
-			firstErr = err
+	return t.SetCertificateContext(context.Background(), serverName, cert)
+}
+
+// SetCertificateContext is like SetCertificate but passes ctx down to every tier.
+// Write-behind writes use a context detached from ctx, limited by the background timeout.
+func (t *TieredStore) SetCertificateContext(ctx context.Context, serverName string, cert tls.Certificate) error {
+	written := len(t.stores)
+	if t.cfg.WriteMode == WriteBehind && written > 1 {
+		written = 1
+	}
+	errs := t.setTiers(ctx, 0, written, "set", serverName, cert)
+	if written < len(t.stores) {
+		t.pending.Add(1)
+		go func() {
+			defer t.pending.Done()
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), t.cfg.BackgroundTimeout)
+			defer cancel()
+			t.report(t.setTiers(ctx, written, len(t.stores), "set", serverName, cert)...)
+		}()
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if t.cfg.WriteErrorPolicy == WriteBestEffort && len(errs) < written {
+		t.report(errs...)
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// setTiers writes cert into the tiers from up to to, returning a *TierError for every failed tier in tier order
+func (t *TieredStore) setTiers(ctx context.Context, from, to int, op, serverName string, cert tls.Certificate) []error {
+	errs := make([]error, to-from)
+	set := func(i int) {
+		if err := t.stores[i].SetCertificateContext(ctx, serverName, cert); err != nil {
+			errs[i-from] = &TierError{Tier: i, Op: op, Err: err}
+		}
+	}
+	if t.cfg.ParallelWrites && to-from > 1 {
+		var wg sync.WaitGroup
+		for i := from; i < to; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				set(i)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i := from; i < to; i++ {
+			set(i)
 		}
 	}
-	return firstErr
+	return slices.DeleteFunc(errs, func(err error) bool { return err == nil })
+}
+
+// report passes tier errors that are not returned to the error handler
+func (t *TieredStore) report(errs ...error) {
+	if t.cfg.ErrorHandler == nil {
+		return
+	}
+	for _, err := range errs {
+		t.cfg.ErrorHandler(err.(*TierError))
+	}
+}
+
+// DeleteCertificate removes the certificate from every tier, so a lower tier cannot serve it again.
+// Every tier must implement store.CertificateDeleter, others fail with errors.ErrUnsupported.
+// Errors of all tiers are joined as *TierError.
+func (t *TieredStore) DeleteCertificate(ctx context.Context, serverName string) error {
+	var errs []error
+	for i, s := range t.tiers {
+		deleter, ok := s.(store.CertificateDeleter)
+		if !ok {
+			errs = append(errs, &TierError{Tier: i, Op: "delete", Err: errors.ErrUnsupported})
+			continue
+		}
+		if err := deleter.DeleteCertificate(ctx, serverName); err != nil {
+			errs = append(errs, &TierError{Tier: i, Op: "delete", Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ListCertificates returns the union of the server names listed by the tiers implementing store.CertificateLister.
+func (t *TieredStore) ListCertificates(ctx context.Context) ([]string, error) {
+	seen := map[string]struct{}{}
+	var names []string
+	for i, s := range t.tiers {
+		lister, ok := s.(store.CertificateLister)
+		if !ok {
+			continue
+		}
+		tierNames, err := lister.ListCertificates(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list certificates of tier %d: %w", i, err)
+		}
+		for _, name := range tierNames {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// StatCertificate returns metadata from the first tier holding the certificate.
+// Tiers not implementing store.CertificateStater are read with GetCertificateContext instead.
+func (t *TieredStore) StatCertificate(ctx context.Context, serverName string) (*store.CertificateInfo, error) {
+	for i, s := range t.stores {
+		info, err := statCertificate(ctx, t.tiers[i], s, serverName)
+		if err == nil {
+			return info, nil
+		}
+		if !store.IsCertificateNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, store.NewCertificateNotFoundError()
+}
+
+func statCertificate(ctx context.Context, tier store.Store, s store.ContextStore, serverName string) (*store.CertificateInfo, error) {
+	if stater, ok := tier.(store.CertificateStater); ok {
+		return stater.StatCertificate(ctx, serverName)
+	}
+	cert, err := s.GetCertificateContext(ctx, serverName)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := store.CertificateLeaf(cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate of %s: %w", serverName, err)
+	}
+	return store.NewCertificateInfo(serverName, leaf), nil
 }
@@ -2,6 +2,8 @@ package caching_test
 
 import (
 	"crypto/tls"
+	"errors"
+	"slices"
 	"sync"
 	"testing"
 
@@ -99,6 +101,257 @@ func TestTieredStore(t *testing.T) {
 	})
 }
 
+func TestTieredStoreMetadata(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	memory1 := caching.NewMemoryStore()
+	memory2 := caching.NewMemoryStore()
+	tieredStore := caching.NewTieredStore(memory1, memory2)
+	if err := memory1.SetCertificate("a.example.com", *cert); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	if err := tieredStore.SetCertificate("b.example.com", *cert); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+
+	names, err := tieredStore.ListCertificates(t.Context())
+	if err != nil {
+		t.Fatalf("ListCertificates failed: %v", err)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("expected the union of tiers to be listed once, got %v", names)
+	}
+
+	if err := tieredStore.DeleteCertificate(t.Context(), "b.example.com"); err != nil {
+		t.Fatalf("DeleteCertificate failed: %v", err)
+	}
+	for _, s := range []*caching.MemoryStore{memory1, memory2} {
+		if _, err := s.GetCertificate("b.example.com"); !store.IsCertificateNotFound(err) {
+			t.Errorf("expected certificate deleted from every tier, got %v", err)
+		}
+	}
+
+	// a tier without the optional interfaces is read through GetCertificate and fails deletes
+	mock := NewMockStore()
+	if err := mock.SetCertificate("c.example.com", *cert); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	mixed := caching.NewTieredStore(memory1, mock)
+	info, err := mixed.StatCertificate(t.Context(), "c.example.com")
+	if err != nil {
+		t.Fatalf("StatCertificate failed: %v", err)
+	}
+	if info.ServerName != "c.example.com" {
+		t.Errorf("unexpected certificate info %+v", info)
+	}
+	if err := mixed.DeleteCertificate(t.Context(), "c.example.com"); err == nil {
+		t.Error("expected deleting through a tier without DeleteCertificate to fail")
+	}
+}
+
+func TestTieredStoreBackfill(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	newTiers := func(t *testing.T) (*MockStore, *MockStore, *MockStore) {
+		store1, store2, store3 := NewMockStore(), NewMockStore(), NewMockStore()
+		if err := store3.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		store3.SetCalls = nil
+		return store1, store2, store3
+	}
+
+	t.Run("none", func(t *testing.T) {
+		store1, store2, store3 := newTiers(t)
+		tieredStore := caching.NewTieredStore(store1, store2, store3)
+		if _, err := tieredStore.GetCertificate("example.com"); err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if len(store1.SetCalls) != 0 || len(store2.SetCalls) != 0 {
+			t.Error("expected higher tiers to be left alone without backfill")
+		}
+	})
+	t.Run("sync", func(t *testing.T) {
+		store1, store2, store3 := newTiers(t)
+		tieredStore := caching.NewTieredStoreWithOptions(
+			[]store.Store{store1, store2, store3},
+			caching.WithBackfill(caching.BackfillSync),
+		)
+		if _, err := tieredStore.GetCertificate("example.com"); err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if len(store1.SetCalls) != 1 || len(store2.SetCalls) != 1 || len(store3.SetCalls) != 0 {
+			t.Errorf("expected tiers above the hit to be backfilled once, got %d, %d and %d writes",
+				len(store1.SetCalls), len(store2.SetCalls), len(store3.SetCalls))
+		}
+		// served from the first tier now
+		if _, err := tieredStore.GetCertificate("example.com"); err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if len(store3.GetCalls) != 1 {
+			t.Errorf("expected backfilled certificate to be served by the first tier, got %d lookups on the last", len(store3.GetCalls))
+		}
+	})
+	t.Run("async", func(t *testing.T) {
+		store1, store2, store3 := newTiers(t)
+		tieredStore := caching.NewTieredStoreWithOptions(
+			[]store.Store{store1, store2, store3},
+			caching.WithBackfill(caching.BackfillAsync),
+		)
+		if _, err := tieredStore.GetCertificate("example.com"); err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		tieredStore.Wait()
+		if _, err := store1.GetCertificate("example.com"); err != nil {
+			t.Errorf("expected first tier to be backfilled, got %v", err)
+		}
+	})
+	t.Run("error policy", func(t *testing.T) {
+		store1, store2, _ := newTiers(t)
+		store2.Certs["example.com"] = cert
+		store1.SetErr = errors.New("tier down")
+
+		var reported []int
+		ignoring := caching.NewTieredStoreWithOptions(
+			[]store.Store{store1, store2},
+			caching.WithBackfill(caching.BackfillSync),
+			caching.WithBackfillErrorHandler(func(tier int, serverName string, err error) {
+				reported = append(reported, tier)
+			}),
+		)
+		if _, err := ignoring.GetCertificate("example.com"); err != nil {
+			t.Fatalf("expected failed backfill to be ignored, got %v", err)
+		}
+		if len(reported) != 1 || reported[0] != 0 {
+			t.Errorf("expected failed tier 0 to be reported, got %v", reported)
+		}
+
+		failing := caching.NewTieredStoreWithOptions(
+			[]store.Store{store1, store2},
+			caching.WithBackfill(caching.BackfillSync),
+			caching.WithBackfillErrorPolicy(caching.BackfillErrorFail),
+		)
+		if _, err := failing.GetCertificate("example.com"); !errors.Is(err, store1.SetErr) {
+			t.Errorf("expected backfill error to be returned, got %v", err)
+		}
+	})
+}
+
+func TestTieredStorePolicies(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	errDown := errors.New("tier down")
+
+	t.Run("all must succeed", func(t *testing.T) {
+		store1, store2, store3 := NewMockStore(), NewMockStore(), NewMockStore()
+		store2.SetErr = errDown
+		store3.SetErr = errDown
+		tieredStore := caching.NewTieredStore(store1, store2, store3)
+		err := tieredStore.SetCertificate("example.com", *cert)
+		if !errors.Is(err, errDown) {
+			t.Fatalf("expected tier error, got %v", err)
+		}
+		var tierErr *caching.TierError
+		if !errors.As(err, &tierErr) || tierErr.Tier != 1 || tierErr.Op != "set" {
+			t.Errorf("expected the first failure to be tier 1, got %v", err)
+		}
+		if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+			t.Errorf("expected 2 joined tier errors, got %d", n)
+		}
+	})
+	t.Run("best effort", func(t *testing.T) {
+		store1, store2 := NewMockStore(), NewMockStore()
+		store2.SetErr = errDown
+		var reported []*caching.TierError
+		tieredStore := caching.NewTieredStoreWithOptions(
+			[]store.Store{store1, store2},
+			caching.WithWriteErrorPolicy(caching.WriteBestEffort),
+			caching.WithTierErrorHandler(func(err *caching.TierError) {
+				reported = append(reported, err)
+			}),
+		)
+		if err := tieredStore.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("expected best effort write to succeed, got %v", err)
+		}
+		if len(reported) != 1 || reported[0].Tier != 1 {
+			t.Errorf("expected the failed tier to be reported, got %v", reported)
+		}
+		store1.SetErr = errDown
+		if err := tieredStore.SetCertificate("example.com", *cert); !errors.Is(err, errDown) {
+			t.Errorf("expected best effort write to fail when every tier fails, got %v", err)
+		}
+	})
+	t.Run("write behind", func(t *testing.T) {
+		store1, store2 := NewMockStore(), NewMockStore()
+		store2.SetErr = errDown
+		reported := make(chan *caching.TierError, 1)
+		tieredStore := caching.NewTieredStoreWithOptions(
+			[]store.Store{store1, store2},
+			caching.WithWriteMode(caching.WriteBehind),
+			caching.WithParallelWrites(),
+			caching.WithTierErrorHandler(func(err *caching.TierError) {
+				reported <- err
+			}),
+		)
+		if err := tieredStore.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("expected write behind failure not to be returned, got %v", err)
+		}
+		tieredStore.Wait()
+		if len(store1.SetCalls) != 1 || len(store2.SetCalls) != 1 {
+			t.Errorf("expected every tier to be written once, got %d and %d writes", len(store1.SetCalls), len(store2.SetCalls))
+		}
+		select {
+		case err := <-reported:
+			if err.Tier != 1 {
+				t.Errorf("expected tier 1 to be reported, got %v", err)
+			}
+		default:
+			t.Error("expected the write behind failure to be reported")
+		}
+	})
+	t.Run("parallel writes", func(t *testing.T) {
+		stores := []store.Store{NewMockStore(), NewMockStore(), NewMockStore()}
+		tieredStore := caching.NewTieredStoreWithOptions(stores, caching.WithParallelWrites())
+		if err := tieredStore.SetCertificate("example.com", *cert); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+		for i, s := range stores {
+			if _, err := s.GetCertificate("example.com"); err != nil {
+				t.Errorf("expected tier %d to be written, got %v", i, err)
+			}
+		}
+	})
+	t.Run("read skip", func(t *testing.T) {
+		store1, store2 := NewMockStore(), NewMockStore()
+		store1.GetErr = errDown
+		store2.Certs["example.com"] = cert
+
+		failing := caching.NewTieredStore(store1, store2)
+		if _, err := failing.GetCertificate("example.com"); !errors.Is(err, errDown) {
+			t.Errorf("expected failing tier to fail the lookup, got %v", err)
+		}
+
+		skipping := caching.NewTieredStoreWithOptions(
+			[]store.Store{store1, store2},
+			caching.WithReadErrorPolicy(caching.ReadErrorSkip),
+		)
+		if _, err := skipping.GetCertificate("example.com"); err != nil {
+			t.Fatalf("expected failing tier to be skipped, got %v", err)
+		}
+		_, err := skipping.GetCertificate("missing.example.com")
+		if !store.IsCertificateNotFound(err) || !errors.Is(err, errDown) {
+			t.Errorf("expected not found joined with the skipped error, got %v", err)
+		}
+	})
+}
+
 type MockStore struct {
 	Certs    map[string]*tls.Certificate
 	SetCalls []string
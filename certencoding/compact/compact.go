@@ -0,0 +1,222 @@
+// Package compact implements a versioned binary certencoding codec, storing DER and PKCS#8 bytes
+// without the PEM, base64 and JSON layers, for caches holding many certificates.
+//
+// A version 1 value is laid out as:
+//
+//	magic "acb" | version 1 | chain length | (DER length | DER)... | key length | PKCS#8 key | metadata
+//
+// where lengths are unsigned varints and metadata is a field count followed by fields
+// of a varint tag, a varint length and the value. Readers skip unknown metadata fields.
+//
+// To store compact values in Redis while entries written as JSON stay readable:
+//
+//	cache := cachingredis.New(client, cachingredis.WithCodec("compact", &compact.Marshaler{}))
+package compact
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deployport/airtls/certencoding"
+)
+
+const magic = "acb"
+
+// Version is the format version written by Marshal.
+const Version = 1
+
+// metadata field tags
+const (
+	tagOCSPStaple                 = 1
+	tagSignedCertificateTimestamp = 2
+)
+
+// maxChainLen bounds the chain length read, so corrupt data cannot cause large allocations
+const maxChainLen = 16
+
+// Marshaler implements Marshaler and Unmarshaler for the compact binary encoding.
+// The OCSP staple and signed certificate timestamps of certificates are kept as metadata.
+type Marshaler struct{}
+
+// Marshal writes cert in the compact binary encoding to w.
+func (c *Marshaler) Marshal(cert tls.Certificate, w io.Writer) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("certificate has no chain")
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	size := len(magic) + 1 + binary.MaxVarintLen64*(3+len(cert.Certificate)) + len(key)
+	for _, der := range cert.Certificate {
+		size += len(der)
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, magic...)
+	buf = append(buf, Version)
+	buf = binary.AppendUvarint(buf, uint64(len(cert.Certificate)))
+	for _, der := range cert.Certificate {
+		buf = appendBytes(buf, der)
+	}
+	buf = appendBytes(buf, key)
+
+	fields := 0
+	if len(cert.OCSPStaple) > 0 {
+		fields++
+	}
+	fields += len(cert.SignedCertificateTimestamps)
+	buf = binary.AppendUvarint(buf, uint64(fields))
+	if len(cert.OCSPStaple) > 0 {
+		buf = binary.AppendUvarint(buf, tagOCSPStaple)
+		buf = appendBytes(buf, cert.OCSPStaple)
+	}
+	for _, sct := range cert.SignedCertificateTimestamps {
+		buf = binary.AppendUvarint(buf, tagSignedCertificateTimestamp)
+		buf = appendBytes(buf, sct)
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// Unmarshal reads a certificate in the compact binary encoding from r.
+func (c *Marshaler) Unmarshal(r io.Reader) (*tls.Certificate, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := d.chain()
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := d.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse leaf certificate: %w", err)
+	}
+	if err := certencoding.CheckKeyPair(leaf, key); err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	if err := d.metadata(cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// UnmarshalLeaf reads only the leaf certificate from r, without parsing the private key.
+func (c *Marshaler) UnmarshalLeaf(r io.Reader) (*x509.Certificate, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	count, err := d.uvarint()
+	if err != nil || count == 0 {
+		return nil, errors.New("failed to read certificate chain")
+	}
+	der, err := d.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read leaf certificate: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// decoder reads the fields of a value whose header has been checked
+type decoder struct {
+	r *bytes.Reader
+}
+
+func newDecoder(r io.Reader) (*decoder, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(magic)+1 || string(data[:len(magic)]) != magic {
+		return nil, errors.New("data is not a compact certificate")
+	}
+	if v := data[len(magic)]; v != Version {
+		return nil, fmt.Errorf("unsupported compact certificate version %d", v)
+	}
+	return &decoder{r: bytes.NewReader(data[len(magic)+1:])}, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	return binary.ReadUvarint(d.r)
+}
+
+// bytes reads a length-prefixed byte string, sharing no memory with later reads
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(d.r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *decoder) chain() ([][]byte, error) {
+	count, err := d.uvarint()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate chain: %w", err)
+	}
+	if count == 0 || count > maxChainLen {
+		return nil, fmt.Errorf("invalid certificate chain length %d", count)
+	}
+	chain := make([][]byte, count)
+	for i := range chain {
+		if chain[i], err = d.bytes(); err != nil {
+			return nil, fmt.Errorf("failed to read certificate %d of the chain: %w", i, err)
+		}
+	}
+	return chain, nil
+}
+
+func (d *decoder) metadata(cert *tls.Certificate) error {
+	count, err := d.uvarint()
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+	for range count {
+		tag, err := d.uvarint()
+		if err != nil {
+			return fmt.Errorf("failed to read metadata: %w", err)
+		}
+		value, err := d.bytes()
+		if err != nil {
+			return fmt.Errorf("failed to read metadata field %d: %w", tag, err)
+		}
+		switch tag {
+		case tagOCSPStaple:
+			cert.OCSPStaple = value
+		case tagSignedCertificateTimestamp:
+			cert.SignedCertificateTimestamps = append(cert.SignedCertificateTimestamps, value)
+		}
+	}
+	return nil
+}
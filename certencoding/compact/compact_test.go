@@ -0,0 +1,170 @@
+package compact_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"testing"
+
+	"github.com/deployport/airtls/certencoding"
+	"github.com/deployport/airtls/certencoding/compact"
+	"github.com/deployport/airtls/certencoding/json"
+	"github.com/deployport/airtls/selfsigned"
+)
+
+func TestMarshaler(t *testing.T) {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithIntermediate(true))
+	if err != nil {
+		t.Fatalf("NewAuthority failed: %v", err)
+	}
+	cert, err := authority.Issue("example.com", selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	cert.OCSPStaple = []byte("ocsp response")
+	cert.SignedCertificateTimestamps = [][]byte{[]byte("sct 1"), []byte("sct 2")}
+
+	marshaler := &compact.Marshaler{}
+	var buf bytes.Buffer
+	if err := marshaler.Marshal(*cert, &buf); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := marshaler.Unmarshal(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(decoded.Certificate) != 2 || !decoded.Leaf.Equal(cert.Leaf) {
+		t.Error("expected the full chain to round trip")
+	}
+	if !bytes.Equal(decoded.OCSPStaple, cert.OCSPStaple) || len(decoded.SignedCertificateTimestamps) != 2 {
+		t.Error("expected metadata to round trip")
+	}
+	if _, err := tls.X509KeyPair(pemOf(t, decoded)); err != nil {
+		t.Errorf("expected decoded key to match the leaf, got %v", err)
+	}
+	leaf, err := marshaler.UnmarshalLeaf(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("UnmarshalLeaf failed: %v", err)
+	}
+	if !leaf.Equal(cert.Leaf) {
+		t.Error("expected UnmarshalLeaf to return the leaf")
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := (&json.Marshaler{}).Marshal(*cert, &jsonBuf); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	// base64 alone makes PEM a third larger than DER
+	if buf.Len()*4 > jsonBuf.Len()*3 {
+		t.Errorf("expected compact encoding to be at least a quarter smaller than JSON, got %d and %d bytes", buf.Len(), jsonBuf.Len())
+	}
+
+	// inside the header, the chain, the key and the metadata
+	for _, n := range []int{0, 2, 5, buf.Len() / 2, buf.Len() - 1} {
+		if _, err := marshaler.Unmarshal(bytes.NewReader(buf.Bytes()[:n])); err == nil {
+			t.Errorf("expected data truncated to %d bytes to fail", n)
+		}
+	}
+	future := bytes.Clone(buf.Bytes())
+	future[3] = compact.Version + 1
+	if _, err := marshaler.Unmarshal(bytes.NewReader(future)); err == nil {
+		t.Error("expected an unknown version to fail")
+	}
+
+	other, err := authority.Issue("other.example.com", selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	mismatched := *cert
+	mismatched.PrivateKey = other.PrivateKey
+	var mismatchedBuf bytes.Buffer
+	if err := marshaler.Marshal(mismatched, &mismatchedBuf); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if _, err := marshaler.Unmarshal(&mismatchedBuf); err == nil {
+		t.Error("expected a key not matching the certificate to fail")
+	}
+}
+
+func pemOf(t *testing.T, cert *tls.Certificate) ([]byte, []byte) {
+	t.Helper()
+	jsonCert, err := json.MarshalTLSCert(*cert)
+	if err != nil {
+		t.Fatalf("MarshalTLSCert failed: %v", err)
+	}
+	return []byte(jsonCert.CertPEM), []byte(jsonCert.KeyPEM)
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	cert := benchmarkCertificate(b)
+	for name, codec := range benchmarkCodecs() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			var buf bytes.Buffer
+			for b.Loop() {
+				buf.Reset()
+				if err := codec.Marshal(*cert, &buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len()), "bytes")
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	cert := benchmarkCertificate(b)
+	for name, codec := range benchmarkCodecs() {
+		b.Run(name, func(b *testing.B) {
+			var buf bytes.Buffer
+			if err := codec.Marshal(*cert, &buf); err != nil {
+				b.Fatal(err)
+			}
+			data := buf.Bytes()
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := codec.Unmarshal(bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func benchmarkCodecs() map[string]certencoding.Codec {
+	return map[string]certencoding.Codec{
+		"compact": &compact.Marshaler{},
+		"json":    &json.Marshaler{},
+	}
+}
+
+func benchmarkCertificate(b *testing.B) *tls.Certificate {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithIntermediate(true))
+	if err != nil {
+		b.Fatal(err)
+	}
+	cert, err := authority.Issue("example.com", selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return cert
+}
+
+// Example demonstrates a round trip through the compact encoding.
+func Example() {
+	cert, err := selfsigned.NewGenerator().Generate("example.com")
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+	marshaler := &compact.Marshaler{}
+	var buf bytes.Buffer
+	if err := marshaler.Marshal(*cert, &buf); err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+	decoded, err := marshaler.Unmarshal(&buf)
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+	fmt.Println(decoded.Leaf.DNSNames)
+	// Output: [example.com]
+}
@@ -0,0 +1,191 @@
+// Package encrypted implements a certencoding codec that encrypts another codec's output with AES-GCM,
+// so certificates and their private keys are protected at rest in shared caches.
+package encrypted
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deployport/airtls/certencoding"
+	"github.com/deployport/airtls/store"
+)
+
+// magic starts every envelope, followed by the format version
+const magic = "aenc"
+
+const version = 1
+
+// maxKeyIDLen is the longest key ID, its length is stored in a single byte
+const maxKeyIDLen = 255
+
+// ErrNotEncrypted is returned when decoding data that is not an encrypted envelope.
+var ErrNotEncrypted = errors.New("data is not an encrypted certificate")
+
+// Option configures a Marshaler.
+type Option func(*Config)
+
+// Config holds configuration for Marshaler.
+type Config struct {
+	// AllowPlaintext decodes data that is not an encrypted envelope with the inner codec.
+	AllowPlaintext bool
+}
+
+// WithAllowPlaintext decodes data that is not an encrypted envelope with the inner codec,
+// so entries written before encryption was enabled stay readable. New entries are always encrypted.
+func WithAllowPlaintext() Option {
+	return func(cfg *Config) {
+		cfg.AllowPlaintext = true
+	}
+}
+
+// Marshaler implements certencoding.Codec by sealing the output of an inner codec with AES-GCM.
+//
+// An envelope holds the format version, the ID of the key that sealed it, a random nonce and the ciphertext.
+// The header is authenticated with the ciphertext, so an envelope cannot be moved to another key ID.
+// Decoding picks the key by ID, so entries sealed before a key rotation stay readable.
+type Marshaler struct {
+	inner certencoding.Codec
+	keys  KeyProvider
+	cfg   Config
+}
+
+// New creates a new Marshaler encrypting the encoding of inner with the keys of provider.
+func New(inner certencoding.Codec, keys KeyProvider, opts ...Option) *Marshaler {
+	m := &Marshaler{
+		inner: inner,
+		keys:  keys,
+	}
+	for _, opt := range opts {
+		opt(&m.cfg)
+	}
+	return m
+}
+
+// Marshal encodes cert with the inner codec and writes it sealed with the current key to w.
+func (m *Marshaler) Marshal(cert tls.Certificate, w io.Writer) error {
+	var plaintext bytes.Buffer
+	if err := m.inner.Marshal(cert, &plaintext); err != nil {
+		return err
+	}
+	id, key, err := m.keys.CurrentKey()
+	if err != nil {
+		return fmt.Errorf("failed to get current key: %w", err)
+	}
+	if id == "" || len(id) > maxKeyIDLen {
+		return fmt.Errorf("key ID must be between 1 and %d bytes", maxKeyIDLen)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return fmt.Errorf("invalid key %q: %w", id, err)
+	}
+	header := make([]byte, 0, len(magic)+2+len(id))
+	header = append(header, magic...)
+	header = append(header, version, byte(len(id)))
+	header = append(header, id...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out := append(header, nonce...)
+	out = aead.Seal(out, nonce, plaintext.Bytes(), header)
+	_, err = w.Write(out)
+	return err
+}
+
+// Unmarshal opens the envelope read from r and decodes it with the inner codec.
+// Data sealed with an unknown key returns a *KeyNotFoundError.
+func (m *Marshaler) Unmarshal(r io.Reader) (*tls.Certificate, error) {
+	plaintext, err := m.open(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.inner.Unmarshal(bytes.NewReader(plaintext))
+}
+
+// UnmarshalLeaf opens the envelope read from r and decodes only its leaf certificate
+// when the inner codec implements certencoding.LeafUnmarshaler.
+func (m *Marshaler) UnmarshalLeaf(r io.Reader) (*x509.Certificate, error) {
+	plaintext, err := m.open(r)
+	if err != nil {
+		return nil, err
+	}
+	if leafUnmarshaler, ok := m.inner.(certencoding.LeafUnmarshaler); ok {
+		return leafUnmarshaler.UnmarshalLeaf(bytes.NewReader(plaintext))
+	}
+	cert, err := m.inner.Unmarshal(bytes.NewReader(plaintext))
+	if err != nil {
+		return nil, err
+	}
+	return store.CertificateLeaf(cert)
+}
+
+// KeyID returns the ID of the key that sealed data, to find entries still sealed with a retired key.
+func KeyID(data []byte) (string, error) {
+	id, _, err := parseHeader(data)
+	return id, err
+}
+
+// open reads an envelope from r and returns its plaintext
+func (m *Marshaler) open(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	id, headerLen, err := parseHeader(data)
+	if errors.Is(err, ErrNotEncrypted) && m.cfg.AllowPlaintext {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %w", id, err)
+	}
+	rest := data[headerLen:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("encrypted certificate is truncated")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, data[:headerLen])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt certificate with key %q: %w", id, err)
+	}
+	return plaintext, nil
+}
+
+// parseHeader returns the key ID of an envelope and the length of its header
+func parseHeader(data []byte) (string, int, error) {
+	if len(data) < len(magic)+2 || string(data[:len(magic)]) != magic {
+		return "", 0, ErrNotEncrypted
+	}
+	if v := data[len(magic)]; v != version {
+		return "", 0, fmt.Errorf("unsupported encrypted certificate version %d", v)
+	}
+	idLen := int(data[len(magic)+1])
+	headerLen := len(magic) + 2 + idLen
+	if idLen == 0 || len(data) < headerLen {
+		return "", 0, errors.New("encrypted certificate header is truncated")
+	}
+	return string(data[len(magic)+2 : headerLen]), headerLen, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
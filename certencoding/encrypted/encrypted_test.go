@@ -0,0 +1,126 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/deployport/airtls/caching/cachingfs"
+	"github.com/deployport/airtls/certencoding/encrypted"
+	"github.com/deployport/airtls/certencoding/json"
+	"github.com/deployport/airtls/selfsigned"
+)
+
+func TestMarshaler(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)).Generate("example.com")
+	if err != nil {
+		t.Fatalf("failed to generate self-signed certificate: %v", err)
+	}
+	newKey := func(t *testing.T) []byte {
+		key, err := encrypted.GenerateKey()
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+		return key
+	}
+	ring, err := encrypted.NewKeyRing("k1", map[string][]byte{"k1": newKey(t)})
+	if err != nil {
+		t.Fatalf("NewKeyRing failed: %v", err)
+	}
+	marshaler := encrypted.New(&json.Marshaler{}, ring)
+
+	var sealed bytes.Buffer
+	if err := marshaler.Marshal(*cert, &sealed); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if bytes.Contains(sealed.Bytes(), []byte("PRIVATE KEY")) {
+		t.Fatal("expected the private key not to be stored in plain text")
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		decoded, err := marshaler.Unmarshal(bytes.NewReader(sealed.Bytes()))
+		if err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if !decoded.Leaf.Equal(cert.Leaf) {
+			t.Error("expected decoded certificate to match")
+		}
+		leaf, err := marshaler.UnmarshalLeaf(bytes.NewReader(sealed.Bytes()))
+		if err != nil {
+			t.Fatalf("UnmarshalLeaf failed: %v", err)
+		}
+		if !leaf.Equal(cert.Leaf) {
+			t.Error("expected decoded leaf to match")
+		}
+	})
+	t.Run("rotation", func(t *testing.T) {
+		if err := ring.Add("k2", newKey(t)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if err := ring.Rotate("k2"); err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+		var rotated bytes.Buffer
+		if err := marshaler.Marshal(*cert, &rotated); err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		for want, data := range map[string][]byte{"k1": sealed.Bytes(), "k2": rotated.Bytes()} {
+			if id, err := encrypted.KeyID(data); err != nil || id != want {
+				t.Errorf("expected key ID %s, got %q and %v", want, id, err)
+			}
+			if _, err := marshaler.Unmarshal(bytes.NewReader(data)); err != nil {
+				t.Errorf("expected entry sealed with %s to stay readable, got %v", want, err)
+			}
+		}
+
+		other, err := encrypted.NewKeyRing("k3", map[string][]byte{"k3": newKey(t)})
+		if err != nil {
+			t.Fatalf("NewKeyRing failed: %v", err)
+		}
+		_, err = encrypted.New(&json.Marshaler{}, other).Unmarshal(bytes.NewReader(sealed.Bytes()))
+		if !encrypted.IsKeyNotFound(err) {
+			t.Errorf("expected key not found, got %v", err)
+		}
+	})
+	t.Run("tampering", func(t *testing.T) {
+		tampered := bytes.Clone(sealed.Bytes())
+		tampered[len(tampered)-1] ^= 1
+		if _, err := marshaler.Unmarshal(bytes.NewReader(tampered)); err == nil {
+			t.Error("expected tampered ciphertext to fail")
+		}
+	})
+	t.Run("plaintext", func(t *testing.T) {
+		var plain bytes.Buffer
+		if err := (&json.Marshaler{}).Marshal(*cert, &plain); err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if _, err := marshaler.Unmarshal(bytes.NewReader(plain.Bytes())); !errors.Is(err, encrypted.ErrNotEncrypted) {
+			t.Errorf("expected plaintext to be rejected, got %v", err)
+		}
+		migrating := encrypted.New(&json.Marshaler{}, ring, encrypted.WithAllowPlaintext())
+		if _, err := migrating.Unmarshal(bytes.NewReader(plain.Bytes())); err != nil {
+			t.Errorf("expected plaintext to be read while migrating, got %v", err)
+		}
+	})
+}
+
+// ExampleNew demonstrates how to encrypt the certificates kept on disk.
+func ExampleNew() {
+	// load the key from a secret manager in production code
+	key, err := encrypted.GenerateKey()
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+	ring, err := encrypted.NewKeyRing("2026-10", map[string][]byte{"2026-10": key})
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+	dirStore, err := cachingfs.New(
+		"/var/lib/airtls/certs",
+		cachingfs.WithCodec(encrypted.New(&json.Marshaler{}, ring)),
+	)
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+	_ = dirStore
+}
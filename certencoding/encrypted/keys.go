@@ -0,0 +1,116 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// KeyProvider supplies the AES keys used to encrypt and decrypt certificates.
+// Keys must be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key used to encrypt new entries.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID, used to decrypt entries.
+	// If the key is unknown, it returns a *KeyNotFoundError.
+	Key(id string) ([]byte, error)
+}
+
+// KeyNotFoundError is returned when an entry was encrypted with a key the KeyProvider does not know.
+type KeyNotFoundError struct {
+	// ID is the ID of the missing key.
+	ID string
+}
+
+// NewKeyNotFoundError creates a new instance of KeyNotFoundError
+func NewKeyNotFoundError(id string) *KeyNotFoundError {
+	return &KeyNotFoundError{ID: id}
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("encryption key %q not found", e.ID)
+}
+
+// IsKeyNotFound checks if the error is a KeyNotFoundError.
+func IsKeyNotFound(err error) bool {
+	var target *KeyNotFoundError
+	return errors.As(err, &target)
+}
+
+// GenerateKey returns a new random AES-256 key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// KeyRing implements KeyProvider with keys held in memory.
+//
+// Keys are rotated by adding a new key and making it current with Rotate. Older keys must be kept
+// until every entry encrypted with them has been rewritten or has expired.
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing creates a new KeyRing encrypting with the key currentID of keys.
+func NewKeyRing(currentID string, keys map[string][]byte) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if err := ring.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := ring.Rotate(currentID); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Add adds a key that can decrypt entries, without encrypting new entries with it.
+func (k *KeyRing) Add(id string, key []byte) error {
+	if id == "" || len(id) > maxKeyIDLen {
+		return fmt.Errorf("key ID must be between 1 and %d bytes", maxKeyIDLen)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid key %q: %w", id, err)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	return nil
+}
+
+// Rotate makes the key id current, encrypting new entries with it.
+func (k *KeyRing) Rotate(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return NewKeyNotFoundError(id)
+	}
+	k.current = id
+	return nil
+}
+
+// CurrentKey returns the ID and the key used to encrypt new entries.
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns the key with the given ID.
+func (k *KeyRing) Key(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, NewKeyNotFoundError(id)
+	}
+	return key, nil
+}
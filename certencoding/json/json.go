@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"io"
 )
 
@@ -55,6 +56,21 @@ func UnmarshalTLSCert(jsonCert Certificate) (*tls.Certificate, error) {
 	return &cert, nil
 }
 
+// UnmarshalLeaf parses the leaf certificate of a Certificate without decoding the private key
+func UnmarshalLeaf(jsonCert Certificate) (*x509.Certificate, error) {
+	rest := []byte(jsonCert.CertPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no certificate found in PEM data")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
 // Marshaler implements Marshaler and Unmarshaler for JSON encoding.
 type Marshaler struct{}
 
@@ -78,3 +94,13 @@ func (j *Marshaler) Unmarshal(r io.Reader) (*tls.Certificate, error) {
 	}
 	return UnmarshalTLSCert(jsonCert)
 }
+
+// UnmarshalLeaf deserializes only the leaf certificate from compact JSON read from r.
+func (j *Marshaler) UnmarshalLeaf(r io.Reader) (*x509.Certificate, error) {
+	var jsonCert Certificate
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&jsonCert); err != nil {
+		return nil, err
+	}
+	return UnmarshalLeaf(jsonCert)
+}
@@ -1,7 +1,10 @@
 package certencoding
 
 import (
+	"crypto"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
 )
 
@@ -14,3 +17,29 @@ type Marshaler interface {
 type Unmarshaler interface {
 	Unmarshal(r io.Reader) (*tls.Certificate, error)
 }
+
+// Codec defines an interface for both serializing and deserializing a tls.Certificate.
+type Codec interface {
+	Marshaler
+	Unmarshaler
+}
+
+// LeafUnmarshaler defines an interface for decoding only the leaf certificate from an io.Reader,
+// without parsing the private key.
+type LeafUnmarshaler interface {
+	UnmarshalLeaf(r io.Reader) (*x509.Certificate, error)
+}
+
+// CheckKeyPair returns an error unless key is the private key of leaf,
+// for codecs decoding the key and certificate separately.
+func CheckKeyPair(leaf *x509.Certificate, key crypto.PrivateKey) error {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return errors.New("private key is not a signer")
+	}
+	pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(signer.Public()) {
+		return errors.New("private key does not match the certificate public key")
+	}
+	return nil
+}
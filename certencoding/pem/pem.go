@@ -0,0 +1,185 @@
+// Package pem implements a certencoding codec for combined PEM bundles: the certificate chain,
+// leaf first, followed by the private key, as read by nginx, HAProxy and most TLS tooling.
+package pem
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PEM block types
+const (
+	blockCertificate   = "CERTIFICATE"
+	blockPKCS8Key      = "PRIVATE KEY"
+	blockPKCS1Key      = "RSA PRIVATE KEY"
+	blockSEC1Key       = "EC PRIVATE KEY"
+	blockEncryptedKey  = "ENCRYPTED PRIVATE KEY"
+	procTypeHeaderName = "Proc-Type"
+)
+
+// KeyFormat selects the encoding of the private key block.
+type KeyFormat int
+
+const (
+	// KeyFormatPKCS8 writes a "PRIVATE KEY" block for every key type.
+	KeyFormatPKCS8 KeyFormat = iota
+	// KeyFormatTraditional writes RSA keys as PKCS#1 "RSA PRIVATE KEY" and ECDSA keys as SEC1 "EC PRIVATE KEY"
+	// blocks, for older tools. Other key types fall back to PKCS#8.
+	KeyFormatTraditional
+)
+
+// Option configures a Marshaler.
+type Option func(*Config)
+
+// Config holds configuration for Marshaler.
+type Config struct {
+	// KeyFormat is the encoding of the private key block.
+	KeyFormat KeyFormat
+}
+
+// WithKeyFormat sets the encoding of the private key block, PKCS#8 by default.
+func WithKeyFormat(format KeyFormat) Option {
+	return func(cfg *Config) {
+		cfg.KeyFormat = format
+	}
+}
+
+// Marshaler implements Marshaler and Unmarshaler for combined PEM bundles.
+type Marshaler struct {
+	cfg Config
+}
+
+// New creates a new Marshaler with options.
+func New(opts ...Option) *Marshaler {
+	m := &Marshaler{}
+	for _, opt := range opts {
+		opt(&m.cfg)
+	}
+	return m
+}
+
+// Marshal writes the certificate chain followed by the private key to w.
+func (m *Marshaler) Marshal(cert tls.Certificate, w io.Writer) error {
+	data, err := Encode(cert, m.cfg.KeyFormat)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Unmarshal reads a PEM bundle from r, see Decode.
+func (m *Marshaler) Unmarshal(r io.Reader) (*tls.Certificate, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// UnmarshalLeaf reads the first certificate of a PEM bundle from r without parsing the private key.
+func (m *Marshaler) UnmarshalLeaf(r io.Reader) (*x509.Certificate, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found in PEM data")
+		}
+		if block.Type == blockCertificate {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// Encode returns the PEM bundle of cert: its chain in order followed by its private key in format.
+func Encode(cert tls.Certificate, format KeyFormat) ([]byte, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("certificate has no chain")
+	}
+	var buf bytes.Buffer
+	for _, der := range cert.Certificate {
+		if err := pem.Encode(&buf, &pem.Block{Type: blockCertificate, Bytes: der}); err != nil {
+			return nil, err
+		}
+	}
+	keyBlock, err := encodeKey(cert.PrivateKey, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(&buf, keyBlock); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode parses a PEM bundle holding a certificate chain, leaf first, and a PKCS#1, SEC1 or PKCS#8
+// private key in any order. Blocks of other types, such as "EC PARAMETERS", and text between blocks are ignored.
+// Encrypted private keys are not supported.
+func Decode(data []byte) (*tls.Certificate, error) {
+	var certPEM []byte
+	var keyBlock *pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case blockCertificate:
+			certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+		case blockPKCS8Key, blockPKCS1Key, blockSEC1Key:
+			if keyBlock != nil {
+				return nil, errors.New("PEM data holds more than one private key")
+			}
+			if _, ok := block.Headers[procTypeHeaderName]; ok {
+				return nil, errors.New("encrypted PEM private keys are not supported")
+			}
+			keyBlock = block
+		case blockEncryptedKey:
+			return nil, errors.New("encrypted PEM private keys are not supported")
+		}
+	}
+	if certPEM == nil {
+		return nil, errors.New("no certificate found in PEM data")
+	}
+	if keyBlock == nil {
+		return nil, errors.New("no private key found in PEM data")
+	}
+	// X509KeyPair parses all three key encodings and checks the key matches the leaf
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(keyBlock))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func encodeKey(key any, format KeyFormat) (*pem.Block, error) {
+	if format == KeyFormatTraditional {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return &pem.Block{Type: blockPKCS1Key, Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+		case *ecdsa.PrivateKey:
+			der, err := x509.MarshalECPrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal EC private key: %w", err)
+			}
+			return &pem.Block{Type: blockSEC1Key, Bytes: der}, nil
+		}
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return &pem.Block{Type: blockPKCS8Key, Bytes: der}, nil
+}
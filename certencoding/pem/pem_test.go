@@ -0,0 +1,139 @@
+package pem_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	stdpem "encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/deployport/airtls/caching/cachingfs"
+	"github.com/deployport/airtls/certencoding/pem"
+	"github.com/deployport/airtls/selfsigned"
+)
+
+func TestMarshaler(t *testing.T) {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithIntermediate(true))
+	if err != nil {
+		t.Fatalf("NewAuthority failed: %v", err)
+	}
+
+	keyTypes := []selfsigned.KeyType{selfsigned.KeyTypeRSA, selfsigned.KeyTypeECDSAP256, selfsigned.KeyTypeEd25519}
+	keyFormats := map[pem.KeyFormat]map[selfsigned.KeyType]string{
+		pem.KeyFormatPKCS8: {
+			selfsigned.KeyTypeRSA:       "PRIVATE KEY",
+			selfsigned.KeyTypeECDSAP256: "PRIVATE KEY",
+			selfsigned.KeyTypeEd25519:   "PRIVATE KEY",
+		},
+		pem.KeyFormatTraditional: {
+			selfsigned.KeyTypeRSA:       "RSA PRIVATE KEY",
+			selfsigned.KeyTypeECDSAP256: "EC PRIVATE KEY",
+			selfsigned.KeyTypeEd25519:   "PRIVATE KEY",
+		},
+	}
+	for format, blockTypes := range keyFormats {
+		for _, keyType := range keyTypes {
+			t.Run(keyType.String()+"/"+blockTypes[keyType], func(t *testing.T) {
+				cert, err := authority.Issue("example.com", selfsigned.WithKeyType(keyType))
+				if err != nil {
+					t.Fatalf("Issue failed: %v", err)
+				}
+				marshaler := pem.New(pem.WithKeyFormat(format))
+				var buf bytes.Buffer
+				if err := marshaler.Marshal(*cert, &buf); err != nil {
+					t.Fatalf("Marshal failed: %v", err)
+				}
+				var types []string
+				for rest := buf.Bytes(); ; {
+					var block *stdpem.Block
+					if block, rest = stdpem.Decode(rest); block == nil {
+						break
+					}
+					types = append(types, block.Type)
+				}
+				want := []string{"CERTIFICATE", "CERTIFICATE", blockTypes[keyType]}
+				if strings.Join(types, ",") != strings.Join(want, ",") {
+					t.Errorf("expected blocks %v, got %v", want, types)
+				}
+
+				decoded, err := marshaler.Unmarshal(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("Unmarshal failed: %v", err)
+				}
+				if len(decoded.Certificate) != len(cert.Certificate) || !decoded.Leaf.Equal(cert.Leaf) {
+					t.Error("expected the full chain to round trip")
+				}
+			})
+		}
+	}
+
+	cert, err := authority.Issue("example.com", selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	bundle, err := pem.Encode(*cert, pem.KeyFormatTraditional)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	t.Run("combined file", func(t *testing.T) {
+		// as written by openssl, key first with parameters and comments
+		chainEnd := bytes.LastIndex(bundle, []byte("-----END CERTIFICATE-----\n")) + len("-----END CERTIFICATE-----\n")
+		var combined bytes.Buffer
+		combined.WriteString("# example.com\n")
+		stdpem.Encode(&combined, &stdpem.Block{Type: "EC PARAMETERS", Bytes: []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}})
+		combined.Write(bundle[chainEnd:])
+		combined.WriteString("subject=CN = example.com\n")
+		combined.Write(bundle[:chainEnd])
+		decoded, err := pem.Decode(combined.Bytes())
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if !decoded.Leaf.Equal(cert.Leaf) || len(decoded.Certificate) != 2 {
+			t.Error("expected the combined file to decode to the chain")
+		}
+		leaf, err := pem.New().UnmarshalLeaf(bytes.NewReader(combined.Bytes()))
+		if err != nil {
+			t.Fatalf("UnmarshalLeaf failed: %v", err)
+		}
+		if !leaf.Equal(cert.Leaf) {
+			t.Error("expected UnmarshalLeaf to return the leaf")
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		other, err := authority.Issue("other.example.com", selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256))
+		if err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		otherKey, err := x509.MarshalPKCS8PrivateKey(other.PrivateKey)
+		if err != nil {
+			t.Fatalf("MarshalPKCS8PrivateKey failed: %v", err)
+		}
+		chainEnd := bytes.LastIndex(bundle, []byte("-----END CERTIFICATE-----\n")) + len("-----END CERTIFICATE-----\n")
+		chain := bundle[:chainEnd]
+		cases := map[string][]byte{
+			"no key":         chain,
+			"no certificate": bundle[chainEnd:],
+			"mismatched key": append(bytes.Clone(chain), stdpem.EncodeToMemory(&stdpem.Block{Type: "PRIVATE KEY", Bytes: otherKey})...),
+			"two keys":       append(bytes.Clone(bundle), stdpem.EncodeToMemory(&stdpem.Block{Type: "PRIVATE KEY", Bytes: otherKey})...),
+		}
+		for name, data := range cases {
+			if _, err := pem.Decode(data); err == nil {
+				t.Errorf("expected %s to fail", name)
+			}
+		}
+	})
+}
+
+// ExampleNew demonstrates how to keep certificates on disk as combined PEM files other tools can read.
+func ExampleNew() {
+	dirStore, err := cachingfs.New(
+		"/etc/nginx/certs",
+		cachingfs.WithCodec(pem.New()),
+		cachingfs.WithFileExtension(".pem"),
+	)
+	if err != nil {
+		panic(err) // Handle error appropriately in production code
+	}
+	_ = dirStore
+}
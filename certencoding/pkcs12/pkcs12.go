@@ -0,0 +1,113 @@
+// Package pkcs12 implements a certencoding codec for PKCS#12 (.p12, .pfx) files,
+// the format expected by Java keystores and Windows.
+package pkcs12
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deployport/airtls/certencoding"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// DefaultPassword is the default password of PKCS#12 files, "changeit" as used by Java keystores.
+// Files protected with it must be protected by other means.
+const DefaultPassword = gopkcs12.DefaultPassword
+
+// Option configures a Marshaler.
+type Option func(*Config)
+
+// Config holds configuration for Marshaler.
+type Config struct {
+	// Password protects the private key and the integrity of the file.
+	Password string
+	// Encoder sets the encryption and MAC algorithms of written files.
+	Encoder *gopkcs12.Encoder
+}
+
+// WithPassword sets the password of written and read files, DefaultPassword by default.
+// Use a high-entropy password, the key derivation is not meant to resist brute force.
+func WithPassword(password string) Option {
+	return func(cfg *Config) {
+		cfg.Password = password
+	}
+}
+
+// WithEncoder sets the encryption and MAC algorithms of written files, gopkcs12.Modern by default
+// (PBES2 with AES-256-CBC and an HMAC-SHA-256 MAC). gopkcs12.Legacy is readable by older consumers
+// such as Java 8 and Windows Server 2016, but its encryption is weak.
+func WithEncoder(encoder *gopkcs12.Encoder) Option {
+	return func(cfg *Config) {
+		cfg.Encoder = encoder
+	}
+}
+
+// Marshaler implements Marshaler and Unmarshaler for PKCS#12 files holding
+// the private key, the leaf certificate and its chain.
+type Marshaler struct {
+	cfg Config
+}
+
+// New creates a new Marshaler with options.
+func New(opts ...Option) *Marshaler {
+	cfg := Config{
+		Password: DefaultPassword,
+		Encoder:  gopkcs12.Modern,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.Encoder == nil {
+		cfg.Encoder = gopkcs12.Modern
+	}
+	return &Marshaler{cfg: cfg}
+}
+
+// Marshal writes cert as a PKCS#12 file to w.
+func (m *Marshaler) Marshal(cert tls.Certificate, w io.Writer) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("certificate has no chain")
+	}
+	chain := make([]*x509.Certificate, len(cert.Certificate))
+	for i, der := range cert.Certificate {
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate %d of the chain: %w", i, err)
+		}
+		chain[i] = parsed
+	}
+	data, err := m.cfg.Encoder.Encode(cert.PrivateKey, chain[0], chain[1:], m.cfg.Password)
+	if err != nil {
+		return fmt.Errorf("failed to encode PKCS#12: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Unmarshal reads a PKCS#12 file from r. The first certificate is taken as the leaf
+// and the following ones as its chain.
+func (m *Marshaler) Unmarshal(r io.Reader) (*tls.Certificate, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	key, leaf, chain, err := gopkcs12.DecodeChain(data, m.cfg.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS#12: %w", err)
+	}
+	if err := certencoding.CheckKeyPair(leaf, key); err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
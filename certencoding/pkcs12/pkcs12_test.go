@@ -0,0 +1,82 @@
+package pkcs12_test
+
+import (
+	"bytes"
+	"crypto"
+	"testing"
+
+	"github.com/deployport/airtls/certencoding/pkcs12"
+	"github.com/deployport/airtls/selfsigned"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+func TestMarshaler(t *testing.T) {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithIntermediate(true))
+	if err != nil {
+		t.Fatalf("NewAuthority failed: %v", err)
+	}
+	for _, keyType := range []selfsigned.KeyType{selfsigned.KeyTypeRSA, selfsigned.KeyTypeECDSAP256} {
+		t.Run(keyType.String(), func(t *testing.T) {
+			cert, err := authority.Issue("example.com", selfsigned.WithKeyType(keyType))
+			if err != nil {
+				t.Fatalf("Issue failed: %v", err)
+			}
+			marshaler := pkcs12.New(pkcs12.WithPassword("s3cret"))
+			var buf bytes.Buffer
+			if err := marshaler.Marshal(*cert, &buf); err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			decoded, err := marshaler.Unmarshal(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if len(decoded.Certificate) != len(cert.Certificate) {
+				t.Fatalf("expected a chain of %d certificates, got %d", len(cert.Certificate), len(decoded.Certificate))
+			}
+			for i := range cert.Certificate {
+				if !bytes.Equal(decoded.Certificate[i], cert.Certificate[i]) {
+					t.Errorf("expected certificate %d of the chain to round trip", i)
+				}
+			}
+			if !decoded.PrivateKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(cert.PrivateKey) {
+				t.Error("expected the private key to round trip")
+			}
+
+			if _, err := pkcs12.New(pkcs12.WithPassword("wrong")).Unmarshal(bytes.NewReader(buf.Bytes())); err == nil {
+				t.Error("expected a wrong password to fail")
+			}
+		})
+	}
+
+	t.Run("mismatched key", func(t *testing.T) {
+		cert, err := authority.Issue("example.com")
+		if err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		other, err := authority.Issue("other.example.com")
+		if err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		data, err := gopkcs12.Modern.Encode(other.PrivateKey, cert.Leaf, nil, pkcs12.DefaultPassword)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		if _, err := pkcs12.New().Unmarshal(bytes.NewReader(data)); err == nil {
+			t.Fatal("expected a key not matching the certificate to fail")
+		}
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		cert, err := authority.Issue("example.com", selfsigned.WithKeyType(selfsigned.KeyTypeRSA))
+		if err != nil {
+			t.Fatalf("Issue failed: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := pkcs12.New(pkcs12.WithEncoder(gopkcs12.Legacy)).Marshal(*cert, &buf); err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if _, err := pkcs12.New().Unmarshal(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("expected legacy files with the default password to be readable, got %v", err)
+		}
+	})
+}
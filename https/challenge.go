@@ -0,0 +1,56 @@
+package https
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// ACMETLSALPNProto is the ALPN protocol negotiated by tls-alpn-01 validation handshakes, see RFC 8737.
+const ACMETLSALPNProto = "acme-tls/1"
+
+// ChallengeCertificateProvider provides the tls-alpn-01 challenge certificates served to
+// handshakes negotiating ACMETLSALPNProto. acmeissuer.TLSALPN01Solver implements it.
+type ChallengeCertificateProvider interface {
+	// ChallengeCertificate returns the pending challenge certificate for serverName, if any.
+	ChallengeCertificate(serverName string) (*tls.Certificate, bool)
+}
+
+// ChallengeRegistry is a concurrent ChallengeCertificateProvider where challenge certificates
+// are registered while a validation is pending.
+type ChallengeRegistry struct {
+	mu    sync.RWMutex
+	certs map[string]*tls.Certificate
+}
+
+// NewChallengeRegistry creates a new ChallengeRegistry instance.
+func NewChallengeRegistry() *ChallengeRegistry {
+	return &ChallengeRegistry{certs: make(map[string]*tls.Certificate)}
+}
+
+// Register serves cert to validation handshakes for serverName until Unregister is called.
+func (r *ChallengeRegistry) Register(serverName string, cert *tls.Certificate) {
+	r.mu.Lock()
+	r.certs[normalizeHost(serverName)] = cert
+	r.mu.Unlock()
+}
+
+// Unregister stops serving the challenge certificate for serverName.
+func (r *ChallengeRegistry) Unregister(serverName string) {
+	r.mu.Lock()
+	delete(r.certs, normalizeHost(serverName))
+	r.mu.Unlock()
+}
+
+// ChallengeCertificate returns the challenge certificate registered for serverName, if any.
+func (r *ChallengeRegistry) ChallengeCertificate(serverName string) (*tls.Certificate, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cert, ok := r.certs[normalizeHost(serverName)]
+	return cert, ok
+}
+
+// isChallengeHello reports whether chi is a tls-alpn-01 validation handshake,
+// which offers ACMETLSALPNProto as its only protocol
+func isChallengeHello(chi *tls.ClientHelloInfo) bool {
+	return len(chi.SupportedProtos) == 1 && chi.SupportedProtos[0] == ACMETLSALPNProto
+}
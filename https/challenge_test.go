@@ -0,0 +1,75 @@
+package https_test
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/https"
+)
+
+func TestGetCertificateChallenge(t *testing.T) {
+	now := time.Now()
+	challengeCert := newTestCertificate(t, "example.com", now, now.Add(time.Hour))
+	registry := https.NewChallengeRegistry()
+	generator := &countingGenerator{}
+	getter, err := https.NewGetCertificate(generator, caching.NewMemoryStore(),
+		https.WithChallengeProvider(registry),
+		https.WithHostPolicy(https.HostAllowlist("example.com")),
+	)
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+	validationHello := &tls.ClientHelloInfo{ServerName: "Example.com", SupportedProtos: []string{https.ACMETLSALPNProto}}
+
+	if _, err := getter(validationHello); err == nil {
+		t.Fatal("expected validation handshake without pending challenge to fail")
+	}
+
+	registry.Register("example.com", challengeCert)
+	cert, err := getter(validationHello)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if cert != challengeCert {
+		t.Error("expected challenge certificate for validation handshake")
+	}
+
+	cert, err = getter(&tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if cert == challengeCert {
+		t.Error("expected regular handshakes not to get the challenge certificate")
+	}
+	// validation handshakes offer nothing but the challenge protocol
+	cert, err = getter(&tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{"h2", https.ACMETLSALPNProto}})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if cert == challengeCert {
+		t.Error("expected handshakes offering other protocols not to get the challenge certificate")
+	}
+	if generator.Calls() != 1 {
+		t.Errorf("expected only the regular handshake to generate, got %d generate calls", generator.Calls())
+	}
+
+	registry.Unregister("example.com")
+	if _, err := getter(validationHello); err == nil {
+		t.Fatal("expected validation handshake to fail after unregistering")
+	}
+}
+
+func TestNewServerChallengeProtocol(t *testing.T) {
+	srv, err := https.NewServer(&countingGenerator{}, caching.NewMemoryStore(), nil,
+		https.WithGetCertificateOptions(https.WithChallengeProvider(https.NewChallengeRegistry())),
+	)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	want := []string{"h2", "http/1.1", https.ACMETLSALPNProto}
+	if got := srv.TLSConfig.NextProtos; len(got) != len(want) || got[2] != want[2] {
+		t.Errorf("expected protocols %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,74 @@
+package https
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+)
+
+// flightGroup deduplicates concurrent certificate generation for the same server name.
+// Callers for a key already in flight wait for the running call and share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{}
+	cert    *tls.Certificate
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do runs fn once for all concurrent callers of key.
+// fn runs with a context that keeps the values of the first caller's ctx but is only cancelled
+// once every waiting caller has given up, so one handshake going away does not fail the others.
+func (g *flightGroup) do(
+	ctx context.Context,
+	key string,
+	fn func(ctx context.Context) (*tls.Certificate, error),
+) (*tls.Certificate, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			c.cert, c.err = fn(flightCtx)
+			cancel()
+			g.forget(key, c)
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.cert, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		abandoned := c.waiters == 0
+		g.mu.Unlock()
+		if abandoned {
+			c.cancel()
+			g.forget(key, c)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes c from the in-flight calls unless a newer call replaced it.
+func (g *flightGroup) forget(key string, c *flightCall) {
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+}
@@ -1,8 +1,11 @@
 package https
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"sync"
+	"time"
 
 	certstore "github.com/deployport/airtls/store"
 )
@@ -10,12 +13,163 @@ import (
 // GetCertificateFunc is a function type that retrieves or generates a TLS certificate for a given host
 type GetCertificateFunc func(chi *tls.ClientHelloInfo) (*tls.Certificate, error)
 
+// DefaultRenewalWindow is the default window before expiry in which certificates are renewed.
+var DefaultRenewalWindow = 30 * 24 * time.Hour
+
+// DefaultRetryBackoff is the default time a server name waits before generating again after a failure.
+var DefaultRetryBackoff = time.Minute
+
+// maxRetryBackoff caps the backoff doubling on consecutive failures
+const maxRetryBackoff = time.Hour
+
+// GetCertificateOption configures the function returned by NewGetCertificate.
+type GetCertificateOption func(*GetCertificateConfig)
+
+// GetCertificateConfig holds configuration for NewGetCertificate.
+type GetCertificateConfig struct {
+	// RenewalWindow is how long before expiry a stored certificate gets regenerated.
+	// For short-lived certificates the window is capped to a third of their lifetime.
+	RenewalWindow time.Duration
+	// RetryBackoff is how long a server name waits before generating again after a failure,
+	// doubled on every consecutive failure up to an hour. Zero retries on every handshake.
+	RetryBackoff time.Duration
+	// Renewer, when set, tracks every server name served so it can renew certificates ahead of time.
+	// Handshakes and the renewer share a single generation of the same server name.
+	Renewer *Renewer
+	// Locker, when set, serializes generation of a server name across processes sharing the store.
+	Locker certstore.Locker
+	// LockFallback decides what happens when Locker fails to acquire the lock.
+	LockFallback LockFallback
+	// HostPolicy, when set, decides which server names get certificates.
+	HostPolicy HostPolicy
+	// DefaultServerName is used when the client sends no SNI, "localhost" by default.
+	// When empty, handshakes without SNI are rejected unless DefaultCertificate is set.
+	DefaultServerName string
+	// DefaultCertificate, when set, is served to clients without SNI and for names rejected by HostPolicy
+	// instead of failing the handshake.
+	DefaultCertificate *tls.Certificate
+	// ChallengeProvider, when set, provides the certificates answering tls-alpn-01 validation handshakes.
+	ChallengeProvider ChallengeCertificateProvider
+}
+
+// LockFallback decides what happens when the generation lock cannot be acquired
+type LockFallback int
+
+const (
+	// LockFallbackGenerate generates and stores the certificate without holding the lock
+	LockFallbackGenerate LockFallback = iota
+	// LockFallbackFail fails the handshake, unless a still valid certificate is stored
+	LockFallbackFail
+)
+
+// WithRenewalWindow sets how long before expiry a stored certificate gets regenerated.
+func WithRenewalWindow(window time.Duration) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.RenewalWindow = window
+	}
+}
+
+// WithRetryBackoff sets how long a server name waits before generating again after a failure.
+// The backoff doubles on every consecutive failure, up to an hour.
+func WithRetryBackoff(backoff time.Duration) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.RetryBackoff = backoff
+	}
+}
+
+// WithRenewer registers every served server name with the given renewer,
+// so certificates are renewed in the background before handshakes need to.
+// The renewer should use the same generator and store, handshakes needing a certificate
+// the renewer is already generating wait for it instead of generating another one.
+func WithRenewer(renewer *Renewer) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.Renewer = renewer
+	}
+}
+
+// WithLocker serializes generate-and-store of a server name across processes sharing the store,
+// so they all agree on one certificate. While waiting for the lock another process may store the
+// certificate, which is then served instead of generating a new one.
+// fallback decides what happens when the lock cannot be acquired.
+func WithLocker(locker certstore.Locker, fallback LockFallback) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.Locker = locker
+		cfg.LockFallback = fallback
+	}
+}
+
+// WithHostPolicy restricts which server names get certificates.
+// Rejected names fail the handshake, or get the default certificate when one is configured.
+func WithHostPolicy(policy HostPolicy) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.HostPolicy = policy
+	}
+}
+
+// WithDefaultServerName sets the server name used for clients that send no SNI.
+// An empty name rejects them unless a default certificate is configured.
+func WithDefaultServerName(name string) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.DefaultServerName = name
+	}
+}
+
+// WithDefaultCertificate sets the certificate served to clients without SNI
+// and for names rejected by the host policy.
+func WithDefaultCertificate(cert *tls.Certificate) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.DefaultCertificate = cert
+	}
+}
+
+// WithChallengeProvider answers tls-alpn-01 validation handshakes, those offering only the
+// ACMETLSALPNProto protocol, with the challenge certificate of the provider.
+// Validation handshakes never reach the store or generator, and fail when no challenge is pending.
+// ServeHTTPS adds ACMETLSALPNProto to the server protocols when this option is set.
+func WithChallengeProvider(provider ChallengeCertificateProvider) GetCertificateOption {
+	return func(cfg *GetCertificateConfig) {
+		cfg.ChallengeProvider = provider
+	}
+}
+
 // NewGetCertificate returns a function that retrieves or generates a TLS certificate for a given host
 // using the provided generator and store. If the certificate is not found in the store, it generates a new one.
 // you can use this function as the GetCertificate callback in a tls.Config.
+//
+// Clients without SNI get a certificate for "localhost" unless configured otherwise with WithDefaultServerName
+// or WithDefaultCertificate, and WithHostPolicy restricts which names get certificates at all.
+//
+// Stored certificates inside the renewal window are still served while a new one is generated and stored
+// in the background, handshakes only wait for missing or expired certificates, which are never served.
+// After a failed generation the server name backs off before generating again, see WithRetryBackoff.
+//
+// The handshake context from tls.ClientHelloInfo is passed down to the store and generator when they implement
+// store.ContextStore and store.ContextGenerator, so a handshake deadline or client disconnect cancels them.
 func NewGetCertificate(
 	generator certstore.Generator,
 	store certstore.Store,
+	opts ...GetCertificateOption,
+) (GetCertificateFunc, error) {
+	return newGetCertificate(generator, store, newGetCertificateConfig(opts))
+}
+
+func newGetCertificateConfig(opts []GetCertificateOption) *GetCertificateConfig {
+	cfg := &GetCertificateConfig{
+		RenewalWindow:     DefaultRenewalWindow,
+		RetryBackoff:      DefaultRetryBackoff,
+		DefaultServerName: "localhost",
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// newGetCertificate implements NewGetCertificate for an already resolved configuration.
+func newGetCertificate(
+	generator certstore.Generator,
+	store certstore.Store,
+	cfg *GetCertificateConfig,
 ) (GetCertificateFunc, error) {
 	if generator == nil {
 		return nil, fmt.Errorf("generator is nil")
@@ -23,23 +177,289 @@ func NewGetCertificate(
 	if store == nil {
 		return nil, fmt.Errorf("store is nil")
 	}
+	m := newCertManager(generator, store, cfg.RenewalWindow, handshakeWindowDivisor)
+	m.locker = cfg.Locker
+	m.lockFallback = cfg.LockFallback
+	m.retryBackoff = cfg.RetryBackoff
+	if cfg.Renewer != nil {
+		m.flight = cfg.Renewer.manager.flight
+	}
 	return GetCertificateFunc(func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		ctx := chi.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if cfg.ChallengeProvider != nil && isChallengeHello(chi) {
+			cert, ok := cfg.ChallengeProvider.ChallengeCertificate(normalizeHost(chi.ServerName))
+			if !ok {
+				return nil, fmt.Errorf("no pending tls-alpn-01 challenge for %s", chi.ServerName)
+			}
+			return cert, nil
+		}
 		host := chi.ServerName
 		if host == "" {
-			host = "localhost"
+			if cfg.DefaultCertificate != nil {
+				return cfg.DefaultCertificate, nil
+			}
+			if cfg.DefaultServerName == "" {
+				return nil, fmt.Errorf("missing server name")
+			}
+			host = cfg.DefaultServerName
 		}
-		cert, err := store.GetCertificate(host)
-		if certstore.IsCertificateNotFound(err) {
-			cert, err = generator.Generate(host)
-			if err != nil {
-				return nil, fmt.Errorf("failed to generate certificate for %s: %w", host, err)
+		// one certificate per name, however the client spells it
+		host = normalizeHost(host)
+		if cfg.HostPolicy != nil {
+			if err := cfg.HostPolicy(ctx, host); err != nil {
+				if cfg.DefaultCertificate != nil {
+					return cfg.DefaultCertificate, nil
+				}
+				return nil, err
 			}
-		} else if err != nil {
-			return nil, fmt.Errorf("failed to get certificate for %s: %w", host, err)
 		}
-		if err := store.SetCertificate(host, *cert); err != nil {
-			return nil, fmt.Errorf("failed to store certificate for %s: %w", host, err)
+		cert, err := m.certificate(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Renewer != nil {
+			cfg.Renewer.Track(host)
 		}
 		return cert, nil
 	}), nil
 }
+
+// certState describes where a stored certificate is in its lifetime
+type certState int
+
+const (
+	certValid certState = iota
+	certRenewable
+	certExpired
+)
+
+// Renewal windows are capped to a fraction of the certificate lifetime, wider for the Renewer
+// so it renews short-lived certificates before handshakes have to.
+const (
+	handshakeWindowDivisor = 3
+	renewerWindowDivisor   = 2
+)
+
+// certManager implements retrieving, renewing and generating certificates,
+// used by the GetCertificate callback and the Renewer.
+// A callback configured with a Renewer shares its flight group.
+type certManager struct {
+	generator     certstore.ContextGenerator
+	store         certstore.ContextStore
+	renewalWindow time.Duration
+	windowDivisor int
+	locker        certstore.Locker
+	lockFallback  LockFallback
+	flight        *flightGroup
+	retryBackoff  time.Duration
+
+	mu       sync.Mutex
+	renewing map[string]struct{}
+	failures map[string]generateFailure
+}
+
+// generateFailure records the last failed generation of a server name
+type generateFailure struct {
+	at    time.Time
+	count int
+	err   error
+}
+
+func newCertManager(
+	generator certstore.Generator,
+	store certstore.Store,
+	renewalWindow time.Duration,
+	windowDivisor int,
+) *certManager {
+	return &certManager{
+		generator:     certstore.NewContextGenerator(generator),
+		store:         certstore.NewContextStore(store),
+		renewalWindow: renewalWindow,
+		windowDivisor: windowDivisor,
+		flight:        &flightGroup{},
+		renewing:      map[string]struct{}{},
+		failures:      map[string]generateFailure{},
+	}
+}
+
+// certificate returns a valid certificate for host, generating a new one when missing or expired.
+// Certificates due for renewal are returned right away and renewed in the background.
+// Concurrent callers needing a new certificate for the same host share a single generation.
+func (m *certManager) certificate(ctx context.Context, host string) (*tls.Certificate, error) {
+	cert, err := m.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		switch m.state(cert, time.Now()) {
+		case certValid:
+			return cert, nil
+		case certRenewable:
+			m.renewInBackground(ctx, host)
+			return cert, nil
+		}
+	}
+	if err := m.backoff(host, time.Now()); err != nil {
+		return nil, err
+	}
+	cert, err = m.flight.do(ctx, host, func(ctx context.Context) (*tls.Certificate, error) {
+		return m.refreshWithBackoff(ctx, host)
+	})
+	if err != nil && cert != nil {
+		// the stored certificate has not expired yet, keep serving it until renewal succeeds
+		return cert, nil
+	}
+	return cert, err
+}
+
+// renewInBackground starts renewing host unless it is already being renewed or backing off.
+// The renewal is detached from ctx, the handshake does not wait for it.
+func (m *certManager) renewInBackground(ctx context.Context, host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.renewing[host]; ok || m.backoffLocked(host, time.Now()) != nil {
+		return
+	}
+	m.renewing[host] = struct{}{}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		_, _ = m.flight.do(ctx, host, func(ctx context.Context) (*tls.Certificate, error) {
+			return m.refreshWithBackoff(ctx, host)
+		})
+		m.mu.Lock()
+		delete(m.renewing, host)
+		m.mu.Unlock()
+	}()
+}
+
+// refreshWithBackoff refreshes host, recording failures so later callers back off.
+// Failures caused by every caller giving up are not recorded.
+func (m *certManager) refreshWithBackoff(ctx context.Context, host string) (*tls.Certificate, error) {
+	cert, err := m.refresh(ctx, host)
+	if err != nil && ctx.Err() != nil {
+		return cert, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.failures, host)
+		return cert, nil
+	}
+	failure := m.failures[host]
+	m.failures[host] = generateFailure{at: time.Now(), count: failure.count + 1, err: err}
+	return cert, err
+}
+
+// backoff returns the last failure of host when it must not be generated again yet.
+func (m *certManager) backoff(host string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.backoffLocked(host, now)
+}
+
+func (m *certManager) backoffLocked(host string, now time.Time) error {
+	failure, ok := m.failures[host]
+	if !ok || m.retryBackoff <= 0 {
+		return nil
+	}
+	delay := m.retryBackoff
+	for i := 1; i < failure.count && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryBackoff)
+	if now.Sub(failure.at) >= delay {
+		return nil
+	}
+	return fmt.Errorf("not generating certificate for %s again until %s: %w",
+		host, failure.at.Add(delay).Format(time.RFC3339), failure.err)
+}
+
+// refresh looks up host again, since a previous flight or another process may have stored
+// a new certificate meanwhile, and generates a new certificate unless the stored one is still valid.
+// When renewal fails, the still valid stored certificate is returned along with the error.
+func (m *certManager) refresh(ctx context.Context, host string) (*tls.Certificate, error) {
+	cert, state, err := m.lookupState(ctx, host)
+	if err != nil || state == certValid {
+		return cert, err
+	}
+	if m.locker != nil {
+		unlock, lockErr := m.locker.Lock(ctx, host)
+		if lockErr == nil {
+			defer unlock()
+		}
+		cert, state, err = m.lookupState(ctx, host)
+		if err != nil || state == certValid {
+			return cert, err
+		}
+		if lockErr != nil && m.lockFallback == LockFallbackFail {
+			return renewableOrNil(cert, state), fmt.Errorf("failed to lock certificate generation for %s: %w", host, lockErr)
+		}
+	}
+	renewed, err := m.generate(ctx, host)
+	if err != nil {
+		return renewableOrNil(cert, state), err
+	}
+	return renewed, nil
+}
+
+// lookupState returns the stored certificate for host along with its state.
+// A missing certificate is reported as expired.
+func (m *certManager) lookupState(ctx context.Context, host string) (*tls.Certificate, certState, error) {
+	cert, err := m.lookup(ctx, host)
+	if err != nil || cert == nil {
+		return nil, certExpired, err
+	}
+	return cert, m.state(cert, time.Now()), nil
+}
+
+// renewableOrNil returns cert if it can still be served while renewal is failing
+func renewableOrNil(cert *tls.Certificate, state certState) *tls.Certificate {
+	if state == certRenewable {
+		return cert
+	}
+	return nil
+}
+
+// lookup returns the stored certificate for host, nil if there is none.
+func (m *certManager) lookup(ctx context.Context, host string) (*tls.Certificate, error) {
+	cert, err := m.store.GetCertificateContext(ctx, host)
+	if certstore.IsCertificateNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate for %s: %w", host, err)
+	}
+	return cert, nil
+}
+
+// generate generates a new certificate for host and stores it.
+func (m *certManager) generate(ctx context.Context, host string) (*tls.Certificate, error) {
+	cert, err := m.generator.GenerateContext(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate for %s: %w", host, err)
+	}
+	if err := m.store.SetCertificateContext(ctx, host, *cert); err != nil {
+		return nil, fmt.Errorf("failed to store certificate for %s: %w", host, err)
+	}
+	return cert, nil
+}
+
+// state reports whether cert is valid, due for renewal or expired at now.
+// Certificates that cannot be parsed are reported as expired so they get replaced.
+func (m *certManager) state(cert *tls.Certificate, now time.Time) certState {
+	leaf, err := certstore.CertificateLeaf(cert)
+	if err != nil || !now.Before(leaf.NotAfter) {
+		return certExpired
+	}
+	window := m.renewalWindow
+	if limit := leaf.NotAfter.Sub(leaf.NotBefore) / time.Duration(m.windowDivisor); window > limit {
+		window = limit
+	}
+	if leaf.NotAfter.Sub(now) <= window {
+		return certRenewable
+	}
+	return certValid
+}
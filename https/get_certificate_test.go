@@ -0,0 +1,652 @@
+package https_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/https"
+	"github.com/deployport/airtls/store"
+)
+
+func TestGetCertificateRenewal(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		notBefore     time.Time
+		notAfter      time.Time
+		wantGenerated bool
+		wantStored    bool
+	}{
+		{
+			name:       "valid",
+			notBefore:  now.Add(-time.Hour),
+			notAfter:   now.Add(90 * 24 * time.Hour),
+			wantStored: true,
+		},
+		{
+			name:          "inside renewal window",
+			notBefore:     now.Add(-80 * 24 * time.Hour),
+			notAfter:      now.Add(10 * 24 * time.Hour),
+			wantGenerated: true,
+			wantStored:    true,
+		},
+		{
+			name:          "short lived inside a third of its lifetime",
+			notBefore:     now.Add(-50 * time.Minute),
+			notAfter:      now.Add(10 * time.Minute),
+			wantGenerated: true,
+			wantStored:    true,
+		},
+		{
+			name:       "short lived outside a third of its lifetime",
+			notBefore:  now.Add(-10 * time.Minute),
+			notAfter:   now.Add(50 * time.Minute),
+			wantStored: true,
+		},
+		{
+			name:          "expired",
+			notBefore:     now.Add(-90 * 24 * time.Hour),
+			notAfter:      now.Add(-time.Hour),
+			wantGenerated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := caching.NewMemoryStore()
+			stored := newTestCertificate(t, "example.com", tt.notBefore, tt.notAfter)
+			if err := store.SetCertificate("example.com", *stored); err != nil {
+				t.Fatalf("SetCertificate failed: %v", err)
+			}
+			generator := &countingGenerator{}
+			getter, err := https.NewGetCertificate(generator, store)
+			if err != nil {
+				t.Fatalf("NewGetCertificate failed: %v", err)
+			}
+			cert, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+			if err != nil {
+				t.Fatalf("GetCertificate failed: %v", err)
+			}
+			if served := cert.Leaf.Equal(stored.Leaf); served != tt.wantStored {
+				t.Errorf("expected stored certificate served=%v", tt.wantStored)
+			}
+			if tt.wantGenerated {
+				waitForCalls(t, generator, 1)
+			}
+			if generated := generator.Calls() == 1; generated != tt.wantGenerated {
+				t.Fatalf("expected generated=%v, got %d generate calls", tt.wantGenerated, generator.Calls())
+			}
+		})
+	}
+}
+
+func TestGetCertificateBackgroundRenewal(t *testing.T) {
+	now := time.Now()
+	store := caching.NewMemoryStore()
+	stored := newTestCertificate(t, "example.com", now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour))
+	if err := store.SetCertificate("example.com", *stored); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	generator := &blockingGenerator{release: make(chan struct{})}
+	getter, err := https.NewGetCertificate(generator, store)
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+	// handshakes never wait for the renewal, which stays blocked until released
+	for range 5 {
+		cert, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if !cert.Leaf.Equal(stored.Leaf) {
+			t.Fatal("expected the stored certificate to be served while renewing")
+		}
+	}
+	close(generator.release)
+	waitForCalls(t, &generator.countingGenerator, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cert, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if !cert.Leaf.Equal(stored.Leaf) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the renewed certificate to be served")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if generator.Calls() != 1 {
+		t.Errorf("expected 1 generate call, got %d", generator.Calls())
+	}
+}
+
+func TestGetCertificateRenewalFailure(t *testing.T) {
+	now := time.Now()
+	store := caching.NewMemoryStore()
+	stored := newTestCertificate(t, "example.com", now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour))
+	if err := store.SetCertificate("example.com", *stored); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	generator := &countingGenerator{fail: true}
+	getter, err := https.NewGetCertificate(generator, store)
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+	cert, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("expected stored certificate to be served when renewal fails, got %v", err)
+	}
+	if !cert.Leaf.Equal(stored.Leaf) {
+		t.Error("expected the stored certificate to be served")
+	}
+	waitForCalls(t, generator, 1)
+
+	// the failed renewal backs off instead of running again on every handshake
+	for range 20 {
+		cert, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil {
+			t.Fatalf("expected stored certificate to be served when renewal fails, got %v", err)
+		}
+		if !cert.Leaf.Equal(stored.Leaf) {
+			t.Error("expected the stored certificate to be served")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if generator.Calls() != 1 {
+		t.Errorf("expected failed renewal not to be retried during backoff, got %d generate calls", generator.Calls())
+	}
+}
+
+func TestGetCertificateRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		backoff   time.Duration
+		wantCalls int
+	}{
+		{name: "default", backoff: https.DefaultRetryBackoff, wantCalls: 1},
+		{name: "disabled", backoff: 0, wantCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := &countingGenerator{fail: true}
+			getter, err := https.NewGetCertificate(generator, caching.NewMemoryStore(),
+				https.WithRetryBackoff(tt.backoff),
+			)
+			if err != nil {
+				t.Fatalf("NewGetCertificate failed: %v", err)
+			}
+			for range 3 {
+				if _, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+					t.Fatal("expected GetCertificate to fail without a certificate")
+				}
+			}
+			if generator.Calls() != tt.wantCalls {
+				t.Errorf("expected %d generate calls, got %d", tt.wantCalls, generator.Calls())
+			}
+		})
+	}
+}
+
+// waitForCalls waits for generator to be called n times by a background renewal.
+func waitForCalls(t *testing.T, generator *countingGenerator, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for generator.Calls() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d generate calls, got %d", n, generator.Calls())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRenewer(t *testing.T) {
+	now := time.Now()
+	store := caching.NewMemoryStore()
+	generator := &countingGenerator{}
+	renewer, err := https.NewRenewer(generator, store)
+	if err != nil {
+		t.Fatalf("NewRenewer failed: %v", err)
+	}
+	getter, err := https.NewGetCertificate(generator, store, https.WithRenewer(renewer))
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+	if _, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"}); err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if generator.Calls() != 1 {
+		t.Fatalf("expected 1 generate call, got %d", generator.Calls())
+	}
+
+	// a certificate outside the handshake window but inside the renewer window
+	soon := newTestCertificate(t, "example.com", now.Add(-80*24*time.Hour), now.Add(40*24*time.Hour))
+	if err := store.SetCertificate("example.com", *soon); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	if err := renewer.RenewDue(t.Context()); err != nil {
+		t.Fatalf("RenewDue failed: %v", err)
+	}
+	if generator.Calls() != 2 {
+		t.Fatalf("expected renewer to generate a certificate, got %d generate calls", generator.Calls())
+	}
+	if err := renewer.RenewDue(t.Context()); err != nil {
+		t.Fatalf("RenewDue failed: %v", err)
+	}
+	if generator.Calls() != 2 {
+		t.Fatalf("expected renewed certificate to be left alone, got %d generate calls", generator.Calls())
+	}
+}
+
+func TestRenewerListsStore(t *testing.T) {
+	now := time.Now()
+	store := caching.NewMemoryStore()
+	generator := &countingGenerator{}
+	renewer, err := https.NewRenewer(generator, store)
+	if err != nil {
+		t.Fatalf("NewRenewer failed: %v", err)
+	}
+	// stored by another process, never tracked by this one
+	soon := newTestCertificate(t, "example.com", now.Add(-80*24*time.Hour), now.Add(40*24*time.Hour))
+	if err := store.SetCertificate("example.com", *soon); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	if err := renewer.RenewDue(t.Context()); err != nil {
+		t.Fatalf("RenewDue failed: %v", err)
+	}
+	if generator.Calls() != 1 {
+		t.Fatalf("expected renewer to renew the stored certificate, got %d generate calls", generator.Calls())
+	}
+}
+
+func TestRenewerRequiresLister(t *testing.T) {
+	plain := struct{ store.Store }{caching.NewMemoryStore()}
+	if _, err := https.NewRenewer(&countingGenerator{}, plain); err == nil {
+		t.Fatal("expected a store that cannot list certificates to be rejected")
+	}
+}
+
+func TestRenewerHostPolicy(t *testing.T) {
+	now := time.Now()
+	store := caching.NewMemoryStore()
+	generator := &countingGenerator{}
+	var skipped []string
+	renewer, err := https.NewRenewer(generator, store,
+		https.WithRenewerHostPolicy(https.HostAllowlist("example.com")),
+		https.WithRenewerErrorHandler(func(serverName string, err error) {
+			if !https.IsHostNotAllowed(err) {
+				t.Errorf("expected %s to be rejected by the host policy, got %v", serverName, err)
+			}
+			skipped = append(skipped, serverName)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewRenewer failed: %v", err)
+	}
+	for _, name := range []string{"example.com", "evil.test"} {
+		soon := newTestCertificate(t, name, now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour))
+		if err := store.SetCertificate(name, *soon); err != nil {
+			t.Fatalf("SetCertificate failed: %v", err)
+		}
+	}
+	if err := renewer.RenewDue(t.Context()); err != nil {
+		t.Fatalf("RenewDue failed: %v", err)
+	}
+	if generator.Calls() != 1 {
+		t.Errorf("expected only the allowed name to be renewed, got %d generate calls", generator.Calls())
+	}
+	if len(skipped) != 1 || skipped[0] != "evil.test" {
+		t.Errorf("expected evil.test to be skipped, got %v", skipped)
+	}
+}
+
+func TestRenewerWindowCap(t *testing.T) {
+	now := time.Now()
+	store := caching.NewMemoryStore()
+	generator := &countingGenerator{}
+	renewer, err := https.NewRenewer(generator, store)
+	if err != nil {
+		t.Fatalf("NewRenewer failed: %v", err)
+	}
+	getter, err := https.NewGetCertificate(generator, store, https.WithRenewer(renewer))
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+	// a 30 day certificate expiring in 12 days, outside a third but inside half of its lifetime
+	short := newTestCertificate(t, "example.com", now.Add(-18*24*time.Hour), now.Add(12*24*time.Hour))
+	if err := store.SetCertificate("example.com", *short); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	if _, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"}); err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if generator.Calls() != 0 {
+		t.Fatalf("expected handshake to serve the stored certificate, got %d generate calls", generator.Calls())
+	}
+	if err := renewer.RenewDue(t.Context()); err != nil {
+		t.Fatalf("RenewDue failed: %v", err)
+	}
+	if generator.Calls() != 1 {
+		t.Fatalf("expected renewer to renew the certificate, got %d generate calls", generator.Calls())
+	}
+}
+
+func TestRenewerDropsMissingNames(t *testing.T) {
+	store := caching.NewMemoryStore()
+	generator := &countingGenerator{}
+	renewer, err := https.NewRenewer(generator, store)
+	if err != nil {
+		t.Fatalf("NewRenewer failed: %v", err)
+	}
+	renewer.Track("gone.example.com")
+	if err := renewer.RenewDue(t.Context()); err != nil {
+		t.Fatalf("RenewDue failed: %v", err)
+	}
+	if generator.Calls() != 0 {
+		t.Fatalf("expected no certificate for a name missing from the store, got %d generate calls", generator.Calls())
+	}
+	// stored again after being dropped, the listed certificate is renewed
+	soon := newTestCertificate(t, "gone.example.com", time.Now().Add(-80*24*time.Hour), time.Now().Add(10*24*time.Hour))
+	if err := store.SetCertificate("gone.example.com", *soon); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	if err := renewer.RenewDue(t.Context()); err != nil {
+		t.Fatalf("RenewDue failed: %v", err)
+	}
+	if generator.Calls() != 1 {
+		t.Fatalf("expected stored certificate to be renewed, got %d generate calls", generator.Calls())
+	}
+}
+
+func TestRenewerSharesFlight(t *testing.T) {
+	now := time.Now()
+	store := &countingStore{memory: caching.NewMemoryStore()}
+	// inside the renewal window of both the renewer and handshakes
+	soon := newTestCertificate(t, "example.com", now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour))
+	if err := store.memory.SetCertificate("example.com", *soon); err != nil {
+		t.Fatalf("SetCertificate failed: %v", err)
+	}
+	release := make(chan struct{})
+	generator := &blockingGenerator{countingGenerator: countingGenerator{}, release: release}
+	renewer, err := https.NewRenewer(generator, store)
+	if err != nil {
+		t.Fatalf("NewRenewer failed: %v", err)
+	}
+	renewer.Track("example.com")
+	getter, err := https.NewGetCertificate(generator, store, https.WithRenewer(renewer))
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var renewErr, getErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		renewErr = renewer.RenewDue(t.Context())
+	}()
+	// the renewer looked up the store and again as flight leader before generating
+	for store.Gets() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		defer wg.Done()
+		_, getErr = getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+	}()
+	for store.Gets() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if renewErr != nil {
+		t.Fatalf("RenewDue failed: %v", renewErr)
+	}
+	if getErr != nil {
+		t.Fatalf("GetCertificate failed: %v", getErr)
+	}
+	if generator.Calls() != 1 {
+		t.Fatalf("expected the handshake renewal to join the renewer, got %d generate calls", generator.Calls())
+	}
+}
+
+// countingGenerator generates certificates valid for 90 days and counts calls.
+// It runs on the flight goroutines, so failures are returned rather than reported to the test.
+type countingGenerator struct {
+	fail  bool
+	mu    sync.Mutex
+	calls int
+}
+
+func (g *countingGenerator) Generate(serverName string) (*tls.Certificate, error) {
+	g.mu.Lock()
+	g.calls++
+	g.mu.Unlock()
+	if g.fail {
+		return nil, errGenerate
+	}
+	now := time.Now()
+	return buildTestCertificate(serverName, now.Add(-time.Minute), now.Add(90*24*time.Hour))
+}
+
+func (g *countingGenerator) Calls() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+var errGenerate = errors.New("generate failed")
+
+func newTestCertificate(t *testing.T, serverName string, notBefore, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	cert, err := buildTestCertificate(serverName, notBefore, notAfter)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return cert
+}
+
+// buildTestCertificate creates a self-signed certificate, safe to call outside the test goroutine
+func buildTestCertificate(serverName string, notBefore, notAfter time.Time) (*tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
+}
+
+func TestGetCertificateSingleFlight(t *testing.T) {
+	const clients = 20
+	store := &countingStore{memory: caching.NewMemoryStore()}
+	release := make(chan struct{})
+	generator := &blockingGenerator{countingGenerator: countingGenerator{}, release: release}
+	getter, err := https.NewGetCertificate(generator, store)
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+
+	certs := make([]*tls.Certificate, clients)
+	errs := make([]error, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs[i], errs[i] = getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+		}()
+	}
+	// every client looked up the store once and the flight leader once more before generating
+	for store.Gets() < clients+1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if generator.Calls() != 1 {
+		t.Fatalf("expected 1 generate call, got %d", generator.Calls())
+	}
+	if store.Sets() != 1 {
+		t.Fatalf("expected 1 store write, got %d", store.Sets())
+	}
+	for i := range clients {
+		if errs[i] != nil {
+			t.Fatalf("client %d failed: %v", i, errs[i])
+		}
+		if !certs[i].Leaf.Equal(certs[0].Leaf) {
+			t.Fatalf("client %d got a different certificate", i)
+		}
+	}
+}
+
+// blockingGenerator blocks generation until release is closed
+type blockingGenerator struct {
+	countingGenerator
+	release chan struct{}
+}
+
+func (g *blockingGenerator) Generate(serverName string) (*tls.Certificate, error) {
+	<-g.release
+	return g.countingGenerator.Generate(serverName)
+}
+
+// countingStore counts calls to the wrapped MemoryStore
+type countingStore struct {
+	memory *caching.MemoryStore
+	mu     sync.Mutex
+	gets   int
+	sets   int
+}
+
+func (s *countingStore) GetCertificate(serverName string) (*tls.Certificate, error) {
+	s.mu.Lock()
+	s.gets++
+	s.mu.Unlock()
+	return s.memory.GetCertificate(serverName)
+}
+
+func (s *countingStore) SetCertificate(serverName string, cert tls.Certificate) error {
+	s.mu.Lock()
+	s.sets++
+	s.mu.Unlock()
+	return s.memory.SetCertificate(serverName, cert)
+}
+
+func (s *countingStore) ListCertificates(ctx context.Context) ([]string, error) {
+	return s.memory.ListCertificates(ctx)
+}
+
+func (s *countingStore) Gets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+func (s *countingStore) Sets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sets
+}
+
+func TestGetCertificateLocker(t *testing.T) {
+	t.Run("certificate stored while waiting", func(t *testing.T) {
+		store := caching.NewMemoryStore()
+		generator := &countingGenerator{}
+		now := time.Now()
+		other := newTestCertificate(t, "example.com", now, now.Add(90*24*time.Hour))
+		locker := lockerFunc(func(ctx context.Context, serverName string) (func(), error) {
+			// another process generated the certificate while we waited for the lock
+			if err := store.SetCertificate(serverName, *other); err != nil {
+				return nil, err
+			}
+			return func() {}, nil
+		})
+		getter, err := https.NewGetCertificate(generator, store, https.WithLocker(locker, https.LockFallbackGenerate))
+		if err != nil {
+			t.Fatalf("NewGetCertificate failed: %v", err)
+		}
+		cert, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if generator.Calls() != 0 {
+			t.Errorf("expected no generate calls, got %d", generator.Calls())
+		}
+		if !cert.Leaf.Equal(other.Leaf) {
+			t.Error("expected the certificate stored by the lock holder to be served")
+		}
+	})
+	errLocked := errors.New("locked")
+	failingLocker := lockerFunc(func(ctx context.Context, serverName string) (func(), error) {
+		return nil, errLocked
+	})
+	t.Run("fallback generate", func(t *testing.T) {
+		generator := &countingGenerator{}
+		getter, err := https.NewGetCertificate(generator, caching.NewMemoryStore(), https.WithLocker(failingLocker, https.LockFallbackGenerate))
+		if err != nil {
+			t.Fatalf("NewGetCertificate failed: %v", err)
+		}
+		if _, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"}); err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if generator.Calls() != 1 {
+			t.Errorf("expected 1 generate call, got %d", generator.Calls())
+		}
+	})
+	t.Run("fallback fail", func(t *testing.T) {
+		generator := &countingGenerator{}
+		getter, err := https.NewGetCertificate(generator, caching.NewMemoryStore(), https.WithLocker(failingLocker, https.LockFallbackFail))
+		if err != nil {
+			t.Fatalf("NewGetCertificate failed: %v", err)
+		}
+		if _, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"}); !errors.Is(err, errLocked) {
+			t.Fatalf("expected lock error, got %v", err)
+		}
+		if generator.Calls() != 0 {
+			t.Errorf("expected no generate calls, got %d", generator.Calls())
+		}
+	})
+}
+
+type lockerFunc func(ctx context.Context, serverName string) (func(), error)
+
+func (f lockerFunc) Lock(ctx context.Context, serverName string) (func(), error) {
+	return f(ctx, serverName)
+}
@@ -3,39 +3,313 @@ package https
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/deployport/airtls/store"
 )
 
+// ServerOption configures the server started by ServeHTTPS.
+type ServerOption func(*ServerConfig)
+
+// ServerConfig holds configuration for the server started by ServeHTTPS.
+// Zero values mean no limit, as in tls.Config and http.Server.
+type ServerConfig struct {
+	// MinVersion is the minimum TLS version accepted, TLS 1.2 by default.
+	MinVersion uint16
+	// CipherSuites restricts the TLS 1.0-1.2 cipher suites, see tls.Config.CipherSuites.
+	CipherSuites []uint16
+	// CurvePreferences sets the key exchange mechanisms in preference order, see tls.Config.CurvePreferences.
+	CurvePreferences []tls.CurveID
+	// NextProtos is the list of ALPN protocols, "h2" and "http/1.1" by default.
+	// Removing "h2" disables HTTP/2.
+	NextProtos []string
+	// ReadTimeout is the maximum duration for reading an entire request.
+	ReadTimeout time.Duration
+	// ReadHeaderTimeout is the maximum duration for reading request headers, 10 seconds by default.
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of the response.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum duration to wait for the next request on keep-alive connections, 2 minutes by default.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes is the maximum size of request headers, http.DefaultMaxHeaderBytes when zero.
+	MaxHeaderBytes int
+	// ErrorLog receives errors from accepting connections, handlers and handshakes, the log package logger when nil.
+	ErrorLog *log.Logger
+	// GetCertificateOptions configures the GetCertificate callback created from the generator and store.
+	GetCertificateOptions []GetCertificateOption
+	// ShutdownTimeout is how long ServeHTTPS waits for in-flight requests to drain once its context is done,
+	// DefaultShutdownTimeout by default.
+	ShutdownTimeout time.Duration
+	// ShutdownHooks are called when the server starts shutting down, see http.Server.RegisterOnShutdown.
+	ShutdownHooks []func()
+	// RedirectAddr, when set, is the address of a plain HTTP server redirecting to HTTPS
+	// run by ServeHTTPS alongside the TLS server.
+	RedirectAddr string
+	// RedirectOptions configures the redirect server.
+	RedirectOptions []RedirectOption
+	// HSTS, when set, is sent as Strict-Transport-Security header on every HTTPS response.
+	HSTS *HSTS
+}
+
+// DefaultShutdownTimeout is the default time ServeHTTPS waits for in-flight requests to drain.
+var DefaultShutdownTimeout = 30 * time.Second
+
+// WithMinTLSVersion sets the minimum TLS version accepted by the server.
+func WithMinTLSVersion(version uint16) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.MinVersion = version
+	}
+}
+
+// WithCipherSuites restricts the TLS 1.0-1.2 cipher suites of the server.
+func WithCipherSuites(suites ...uint16) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.CipherSuites = suites
+	}
+}
+
+// WithCurvePreferences sets the key exchange mechanisms of the server in preference order.
+func WithCurvePreferences(curves ...tls.CurveID) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.CurvePreferences = curves
+	}
+}
+
+// WithNextProtos sets the ALPN protocols of the server, replacing the default "h2" and "http/1.1".
+func WithNextProtos(protos ...string) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.NextProtos = protos
+	}
+}
+
+// WithReadTimeout sets the maximum duration for reading an entire request.
+func WithReadTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ReadTimeout = timeout
+	}
+}
+
+// WithReadHeaderTimeout sets the maximum duration for reading request headers.
+func WithReadHeaderTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ReadHeaderTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets the maximum duration before timing out writes of the response.
+func WithWriteTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.WriteTimeout = timeout
+	}
+}
+
+// WithIdleTimeout sets the maximum duration to wait for the next request on keep-alive connections.
+func WithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.IdleTimeout = timeout
+	}
+}
+
+// WithMaxHeaderBytes sets the maximum size of request headers.
+func WithMaxHeaderBytes(n int) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.MaxHeaderBytes = n
+	}
+}
+
+// WithErrorLog sets the logger receiving server errors.
+func WithErrorLog(logger *log.Logger) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ErrorLog = logger
+	}
+}
+
+// WithGetCertificateOptions configures the GetCertificate callback created from the generator and store.
+func WithGetCertificateOptions(opts ...GetCertificateOption) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.GetCertificateOptions = append(cfg.GetCertificateOptions, opts...)
+	}
+}
+
+// WithShutdownTimeout sets how long ServeHTTPS waits for in-flight requests to drain once its context is done.
+func WithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ShutdownTimeout = timeout
+	}
+}
+
+// WithShutdownHook registers a function called when the server starts shutting down.
+// Hooks run in their own goroutine and should not wait for the shutdown to complete,
+// they are meant to close hijacked connections such as websockets.
+func WithShutdownHook(hook func()) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ShutdownHooks = append(cfg.ShutdownHooks, hook)
+	}
+}
+
+// WithHTTPRedirect runs a plain HTTP server on laddr alongside the TLS server, redirecting every request
+// to HTTPS with the same host, path and query. Redirects point to the port ServeHTTPS listens on unless
+// WithRedirectPort is given. Exempt paths are served over plain HTTP by the ServeHTTPS handler.
+func WithHTTPRedirect(laddr string, opts ...RedirectOption) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.RedirectAddr = laddr
+		cfg.RedirectOptions = append(cfg.RedirectOptions, opts...)
+	}
+}
+
+// WithHSTS sends the Strict-Transport-Security header on every HTTPS response.
+func WithHSTS(hsts HSTS) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.HSTS = &hsts
+	}
+}
+
+func newServerConfig(opts []ServerOption) *ServerConfig {
+	cfg := &ServerConfig{
+		MinVersion:        tls.VersionTLS12,
+		NextProtos:        []string{"h2", "http/1.1"},
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   DefaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// NewServer creates an http.Server whose TLSConfig gets certificates from the provided generator and store.
+// Serve it on a listener wrapped with tls.NewListener using the server TLSConfig, as ServeHTTPS does.
+func NewServer(
+	generator store.Generator,
+	store store.Store,
+	handler http.Handler,
+	opts ...ServerOption,
+) (*http.Server, error) {
+	return newServer(generator, store, handler, newServerConfig(opts))
+}
+
+func newServer(
+	generator store.Generator,
+	store store.Store,
+	handler http.Handler,
+	cfg *ServerConfig,
+) (*http.Server, error) {
+	getterCfg := newGetCertificateConfig(cfg.GetCertificateOptions)
+	getter, err := newGetCertificate(generator, store, getterCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create get certificate: %w", err)
+	}
+	nextProtos := cfg.NextProtos
+	if getterCfg.ChallengeProvider != nil && !slices.Contains(nextProtos, ACMETLSALPNProto) {
+		nextProtos = append(slices.Clip(nextProtos), ACMETLSALPNProto)
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate:   getter,
+		MinVersion:       cfg.MinVersion,
+		CipherSuites:     cfg.CipherSuites,
+		CurvePreferences: cfg.CurvePreferences,
+		NextProtos:       nextProtos,
+	}
+	if cfg.HSTS != nil {
+		handler = hstsHandler(*cfg.HSTS, handler)
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          cfg.ErrorLog,
+	}
+	for _, hook := range cfg.ShutdownHooks {
+		srv.RegisterOnShutdown(hook)
+	}
+	return srv, nil
+}
+
 // ServeHTTPS starts an HTTPS server that uses the provided generator to create certificates
-// and using the http package shared mux handler
+// and using the http package shared mux handler.
+// By default it accepts TLS 1.2 and later and negotiates HTTP/2, see ServerOption for the settings available.
+//
+// When ctx is done the server stops accepting connections and waits up to the shutdown timeout
+// for in-flight requests to complete. A clean shutdown returns nil.
+// If a redirect server is configured with WithHTTPRedirect, both servers stop when either fails.
 func ServeHTTPS(
 	ctx context.Context,
 	generator store.Generator,
 	store store.Store,
 	laddr string,
 	handler http.Handler,
+	opts ...ServerOption,
 ) error {
-	getter, err := NewGetCertificate(generator, store)
+	cfg := newServerConfig(opts)
+	srv, err := newServer(generator, store, handler, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create get certificate: %w", err)
+		return err
 	}
 
-	tlsConfig := &tls.Config{
-		GetCertificate: getter,
-	}
-
-	ln, err := tls.Listen("tcp", laddr, tlsConfig)
+	tcpLn, err := net.Listen("tcp", laddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen for TLS: %w", err)
 	}
+	ln := tls.NewListener(tcpLn, srv.TLSConfig)
+	if cfg.RedirectAddr == "" {
+		return serveUntilDone(ctx, srv, ln, cfg.ShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	_, port, _ := net.SplitHostPort(tcpLn.Addr().String())
+	redirectOpts := append([]RedirectOption{
+		WithRedirectPort(port),
+		WithRedirectShutdownTimeout(cfg.ShutdownTimeout),
+	}, cfg.RedirectOptions...)
+	redirectErr := make(chan error, 1)
+	go func() {
+		err := ServeHTTPRedirect(ctx, cfg.RedirectAddr, handler, redirectOpts...)
+		if err != nil {
+			cancel()
+		}
+		redirectErr <- err
+	}()
+	err = serveUntilDone(ctx, srv, ln, cfg.ShutdownTimeout)
+	cancel()
+	return errors.Join(err, <-redirectErr)
+}
+
+// serveUntilDone serves srv on ln until ctx is done, then shuts it down waiting up to timeout
+// for in-flight requests. It takes ownership of ln and returns nil on a clean shutdown.
+func serveUntilDone(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration) error {
 	defer ln.Close()
+
+	serveErr := make(chan error, 1)
 	go func() {
-		<-ctx.Done()
-		ln.Close()
+		serveErr <- srv.Serve(ln)
 	}()
-	srv := &http.Server{Handler: handler}
-	return srv.Serve(ln)
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		<-serveErr
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
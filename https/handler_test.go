@@ -0,0 +1,198 @@
+package https_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/https"
+	"github.com/deployport/airtls/selfsigned"
+)
+
+func TestNewServer(t *testing.T) {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithAuthorityKeyType(selfsigned.KeyTypeECDSAP256))
+	if err != nil {
+		t.Fatalf("NewAuthority failed: %v", err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+	srv, err := https.NewServer(
+		selfsigned.NewCAGenerator(authority, selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)),
+		caching.NewMemoryStore(),
+		handler,
+		https.WithMinTLSVersion(tls.VersionTLS13),
+	)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go srv.Serve(tls.NewListener(tcpLn, srv.TLSConfig))
+	t.Cleanup(func() { srv.Close() })
+	url := "https://localhost:" + portOf(t, tcpLn.Addr().String()) + "/"
+
+	t.Run("HTTP/2 by default", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: authority.CertPool()},
+			ForceAttemptHTTP2: true,
+			DialContext:       dialLoopback,
+		}}
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("expected HTTP/2.0, got %s", body)
+		}
+	})
+	t.Run("minimum TLS version", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: authority.CertPool(), MaxVersion: tls.VersionTLS12},
+			DialContext:     dialLoopback,
+		}}
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			t.Fatal("expected TLS 1.2 client to be rejected")
+		}
+	})
+}
+
+func portOf(t *testing.T, addr string) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("failed to split address: %v", err)
+	}
+	return port
+}
+
+// dialLoopback dials the loopback address of the requested port, so tests can use "localhost" as server name
+// without depending on name resolution.
+func dialLoopback(ctx context.Context, network, addr string) (net.Conn, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, net.JoinHostPort("127.0.0.1", port))
+}
+
+func TestServeHTTPSGracefulShutdown(t *testing.T) {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithAuthorityKeyType(selfsigned.KeyTypeECDSAP256))
+	if err != nil {
+		t.Fatalf("NewAuthority failed: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: authority.CertPool()},
+		DialContext:     dialLoopback,
+	}}
+
+	for _, tt := range []struct {
+		name            string
+		shutdownTimeout time.Duration
+		wantErr         bool
+	}{
+		{name: "drains in-flight requests", shutdownTimeout: 10 * time.Second},
+		{name: "drain timeout", shutdownTimeout: 50 * time.Millisecond, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+				io.WriteString(w, "done")
+			})
+			hookCalled := make(chan struct{})
+			addr := freeAddr(t)
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			served := make(chan error, 1)
+			go func() {
+				served <- https.ServeHTTPS(
+					ctx,
+					selfsigned.NewCAGenerator(authority, selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)),
+					caching.NewMemoryStore(),
+					addr,
+					handler,
+					https.WithShutdownTimeout(tt.shutdownTimeout),
+					https.WithShutdownHook(func() { close(hookCalled) }),
+				)
+			}()
+			waitListening(t, addr)
+
+			type result struct {
+				body string
+				err  error
+			}
+			responded := make(chan result, 1)
+			go func() {
+				resp, err := client.Get("https://localhost:" + portOf(t, addr) + "/")
+				if err != nil {
+					responded <- result{err: err}
+					return
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				responded <- result{body: string(body), err: err}
+			}()
+			<-started
+			cancel()
+			<-hookCalled
+
+			if tt.wantErr {
+				if err := <-served; err == nil {
+					t.Fatal("expected ServeHTTPS to report the drain timeout")
+				}
+				close(release)
+				return
+			}
+			close(release)
+			res := <-responded
+			if res.err != nil || res.body != "done" {
+				t.Fatalf("expected in-flight request to complete, got %q, %v", res.body, res.err)
+			}
+			if err := <-served; err != nil {
+				t.Fatalf("expected clean shutdown, got %v", err)
+			}
+		})
+	}
+}
+
+// freeAddr returns a loopback address with a port that was free when checked
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start listening on %s: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
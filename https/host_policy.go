@@ -0,0 +1,115 @@
+package https
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// HostPolicy decides whether a certificate may be served for a host.
+// It returns a non-nil error to reject the host, usually a *HostNotAllowedError.
+// Hosts are passed lowercased and without a trailing dot.
+type HostPolicy func(ctx context.Context, host string) error
+
+// HostNotAllowedError is returned when a host policy rejects a host.
+type HostNotAllowedError struct {
+	Host string
+}
+
+// NewHostNotAllowedError creates a new instance of HostNotAllowedError
+func NewHostNotAllowedError(host string) *HostNotAllowedError {
+	return &HostNotAllowedError{Host: host}
+}
+
+func (e *HostNotAllowedError) Error() string {
+	return fmt.Sprintf("host %q not allowed", e.Host)
+}
+
+// IsHostNotAllowed checks if the error is a HostNotAllowedError.
+func IsHostNotAllowed(err error) bool {
+	var target *HostNotAllowedError
+	return errors.As(err, &target)
+}
+
+// HostAllowlist returns a policy that only allows the given hosts, compared case-insensitively.
+func HostAllowlist(hosts ...string) HostPolicy {
+	allowed := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		allowed[normalizeHost(h)] = struct{}{}
+	}
+	return func(_ context.Context, host string) error {
+		if _, ok := allowed[host]; !ok {
+			return NewHostNotAllowedError(host)
+		}
+		return nil
+	}
+}
+
+// HostPatterns returns a policy that allows hosts matching any of the given patterns:
+//   - "*.example.com" matches a single label in place of the wildcard, like a wildcard certificate
+//   - ".example.com" matches any subdomain of example.com, at any depth
+//   - anything else matches the exact host
+func HostPatterns(patterns ...string) HostPolicy {
+	normalized := make([]string, len(patterns))
+	for i, p := range patterns {
+		normalized[i] = normalizeHost(p)
+	}
+	return func(_ context.Context, host string) error {
+		for _, p := range normalized {
+			if matchHostPattern(p, host) {
+				return nil
+			}
+		}
+		return NewHostNotAllowedError(host)
+	}
+}
+
+// HostRegexp returns a policy that allows hosts matching any of the given regular expressions.
+// Expressions must match the whole host, as if anchored with ^ and $.
+func HostRegexp(exprs ...*regexp.Regexp) HostPolicy {
+	anchored := make([]*regexp.Regexp, len(exprs))
+	for i, re := range exprs {
+		// an already valid expression stays valid inside a group
+		anchored[i] = regexp.MustCompile(`^(?:` + re.String() + `)$`)
+	}
+	return func(_ context.Context, host string) error {
+		for _, re := range anchored {
+			if re.MatchString(host) {
+				return nil
+			}
+		}
+		return NewHostNotAllowedError(host)
+	}
+}
+
+// AnyHostPolicy returns a policy that allows hosts allowed by any of the given policies.
+// The error of the last policy is returned when all of them reject the host.
+func AnyHostPolicy(policies ...HostPolicy) HostPolicy {
+	return func(ctx context.Context, host string) error {
+		err := error(NewHostNotAllowedError(host))
+		for _, p := range policies {
+			if err = p(ctx, host); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+func matchHostPattern(pattern, host string) bool {
+	switch {
+	case strings.HasPrefix(pattern, "*."):
+		label, rest, ok := strings.Cut(host, ".")
+		return ok && label != "" && rest == pattern[2:]
+	case strings.HasPrefix(pattern, "."):
+		return strings.HasSuffix(host, pattern) && len(host) > len(pattern)
+	default:
+		return host == pattern
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
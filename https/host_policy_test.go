@@ -0,0 +1,172 @@
+package https_test
+
+import (
+	"context"
+	"crypto/tls"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/https"
+)
+
+func TestHostPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  https.HostPolicy
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "allowlist",
+			policy:  https.HostAllowlist("example.com", "WWW.Example.com."),
+			allowed: []string{"example.com", "www.example.com"},
+			denied:  []string{"api.example.com", "example.org"},
+		},
+		{
+			name:    "wildcard pattern",
+			policy:  https.HostPatterns("*.example.com"),
+			allowed: []string{"api.example.com"},
+			denied:  []string{"example.com", "a.b.example.com", "evil-example.com"},
+		},
+		{
+			name:    "suffix pattern",
+			policy:  https.HostPatterns(".example.com", "example.com"),
+			allowed: []string{"example.com", "api.example.com", "a.b.example.com"},
+			denied:  []string{"evilexample.com", "example.org"},
+		},
+		{
+			name:    "regexp",
+			policy:  https.HostRegexp(regexp.MustCompile(`^[a-z]+\.example\.com$`)),
+			allowed: []string{"api.example.com"},
+			denied:  []string{"api2.example.com", "example.com"},
+		},
+		{
+			name:    "unanchored regexp",
+			policy:  https.HostRegexp(regexp.MustCompile(`example\.com`)),
+			allowed: []string{"example.com"},
+			denied:  []string{"example.com.attacker.net", "evil-example.com"},
+		},
+		{
+			name: "any",
+			policy: https.AnyHostPolicy(
+				https.HostAllowlist("localhost"),
+				func(_ context.Context, host string) error {
+					if host == "callback.test" {
+						return nil
+					}
+					return https.NewHostNotAllowedError(host)
+				},
+			),
+			allowed: []string{"localhost", "callback.test"},
+			denied:  []string{"example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, host := range tt.allowed {
+				if err := tt.policy(t.Context(), host); err != nil {
+					t.Errorf("expected %s to be allowed, got %v", host, err)
+				}
+			}
+			for _, host := range tt.denied {
+				if err := tt.policy(t.Context(), host); !https.IsHostNotAllowed(err) {
+					t.Errorf("expected %s to be denied, got %v", host, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetCertificateHostPolicy(t *testing.T) {
+	now := time.Now()
+	defaultCert := newTestCertificate(t, "default.test", now, now.Add(time.Hour))
+
+	t.Run("rejects unknown names", func(t *testing.T) {
+		generator := &countingGenerator{}
+		getter, err := https.NewGetCertificate(generator, caching.NewMemoryStore(),
+			https.WithHostPolicy(https.HostAllowlist("example.com")),
+		)
+		if err != nil {
+			t.Fatalf("NewGetCertificate failed: %v", err)
+		}
+		if _, err := getter(&tls.ClientHelloInfo{ServerName: "example.com"}); err != nil {
+			t.Fatalf("expected example.com to be allowed, got %v", err)
+		}
+		if _, err := getter(&tls.ClientHelloInfo{ServerName: "evil.test"}); !https.IsHostNotAllowed(err) {
+			t.Fatalf("expected evil.test to be rejected, got %v", err)
+		}
+		if _, err := getter(&tls.ClientHelloInfo{}); !https.IsHostNotAllowed(err) {
+			t.Fatalf("expected default localhost name to be rejected, got %v", err)
+		}
+		if generator.Calls() != 1 {
+			t.Errorf("expected 1 generate call, got %d", generator.Calls())
+		}
+	})
+	t.Run("default certificate", func(t *testing.T) {
+		generator := &countingGenerator{}
+		getter, err := https.NewGetCertificate(generator, caching.NewMemoryStore(),
+			https.WithHostPolicy(https.HostAllowlist("example.com")),
+			https.WithDefaultCertificate(defaultCert),
+		)
+		if err != nil {
+			t.Fatalf("NewGetCertificate failed: %v", err)
+		}
+		for _, name := range []string{"evil.test", ""} {
+			cert, err := getter(&tls.ClientHelloInfo{ServerName: name})
+			if err != nil {
+				t.Fatalf("GetCertificate(%q) failed: %v", name, err)
+			}
+			if cert != defaultCert {
+				t.Errorf("expected default certificate for %q", name)
+			}
+		}
+		if generator.Calls() != 0 {
+			t.Errorf("expected no generate calls, got %d", generator.Calls())
+		}
+	})
+	t.Run("mixed case names share a certificate", func(t *testing.T) {
+		generator := &countingGenerator{}
+		store := caching.NewMemoryStore()
+		getter, err := https.NewGetCertificate(generator, store,
+			https.WithHostPolicy(https.HostAllowlist("example.com")),
+		)
+		if err != nil {
+			t.Fatalf("NewGetCertificate failed: %v", err)
+		}
+		var first *tls.Certificate
+		for _, name := range []string{"example.com", "EXAMPLE.com", "eXample.com."} {
+			cert, err := getter(&tls.ClientHelloInfo{ServerName: name})
+			if err != nil {
+				t.Fatalf("GetCertificate(%q) failed: %v", name, err)
+			}
+			if first == nil {
+				first = cert
+			} else if !cert.Leaf.Equal(first.Leaf) {
+				t.Errorf("expected %q to reuse the example.com certificate", name)
+			}
+		}
+		if generator.Calls() != 1 {
+			t.Errorf("expected 1 generate call, got %d", generator.Calls())
+		}
+		names, err := store.ListCertificates(t.Context())
+		if err != nil {
+			t.Fatalf("ListCertificates failed: %v", err)
+		}
+		if len(names) != 1 || names[0] != "example.com" {
+			t.Errorf("expected only example.com to be stored, got %v", names)
+		}
+	})
+	t.Run("missing server name", func(t *testing.T) {
+		getter, err := https.NewGetCertificate(&countingGenerator{}, caching.NewMemoryStore(),
+			https.WithDefaultServerName(""),
+		)
+		if err != nil {
+			t.Fatalf("NewGetCertificate failed: %v", err)
+		}
+		if _, err := getter(&tls.ClientHelloInfo{}); err == nil {
+			t.Fatal("expected handshake without SNI to be rejected")
+		}
+	})
+}
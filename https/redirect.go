@@ -0,0 +1,162 @@
+package https
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedirectOption configures the plain HTTP redirect server.
+type RedirectOption func(*RedirectConfig)
+
+// RedirectConfig holds configuration for the plain HTTP redirect server.
+type RedirectConfig struct {
+	// Port is the HTTPS port redirects point to. It is left out of the URL when empty or "443".
+	Port string
+	// StatusCode is the redirect status, http.StatusPermanentRedirect by default so methods and bodies are kept.
+	StatusCode int
+	// ExemptPaths are served over plain HTTP instead of being redirected, for example health checks.
+	// A path ending with "/" matches every path below it, other paths match exactly.
+	ExemptPaths []string
+	// ShutdownTimeout is how long the server waits for in-flight requests to drain once its context is done.
+	ShutdownTimeout time.Duration
+}
+
+// WithRedirectPort sets the HTTPS port redirects point to.
+func WithRedirectPort(port string) RedirectOption {
+	return func(cfg *RedirectConfig) {
+		cfg.Port = port
+	}
+}
+
+// WithRedirectStatusCode sets the status code of redirects.
+func WithRedirectStatusCode(code int) RedirectOption {
+	return func(cfg *RedirectConfig) {
+		cfg.StatusCode = code
+	}
+}
+
+// WithRedirectExemptPaths sets paths that are served over plain HTTP instead of being redirected.
+func WithRedirectExemptPaths(paths ...string) RedirectOption {
+	return func(cfg *RedirectConfig) {
+		cfg.ExemptPaths = append(cfg.ExemptPaths, paths...)
+	}
+}
+
+// WithRedirectShutdownTimeout sets how long the redirect server waits for in-flight requests to drain.
+func WithRedirectShutdownTimeout(timeout time.Duration) RedirectOption {
+	return func(cfg *RedirectConfig) {
+		cfg.ShutdownTimeout = timeout
+	}
+}
+
+func newRedirectConfig(opts []RedirectOption) *RedirectConfig {
+	cfg := &RedirectConfig{
+		StatusCode:      http.StatusPermanentRedirect,
+		ShutdownTimeout: DefaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// NewRedirectHandler returns a handler redirecting requests to the same host, path and query over HTTPS.
+// Requests for exempt paths are served by exempt instead, http.NotFoundHandler when nil.
+func NewRedirectHandler(exempt http.Handler, opts ...RedirectOption) http.Handler {
+	return newRedirectHandler(exempt, newRedirectConfig(opts))
+}
+
+func newRedirectHandler(exempt http.Handler, cfg *RedirectConfig) http.Handler {
+	if exempt == nil {
+		exempt = http.NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.isExempt(r.URL.Path) {
+			exempt.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			// IPv6 literal without port, such as "[::1]"
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+		if cfg.Port != "" && cfg.Port != "443" {
+			host = net.JoinHostPort(host, cfg.Port)
+		} else if strings.Contains(host, ":") {
+			// IPv6 literal without port
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), cfg.StatusCode)
+	})
+}
+
+func (cfg *RedirectConfig) isExempt(path string) bool {
+	for _, p := range cfg.ExemptPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTPRedirect starts a plain HTTP server redirecting every request to HTTPS, see NewRedirectHandler.
+// It shuts down gracefully when ctx is done, returning nil on a clean shutdown.
+func ServeHTTPRedirect(
+	ctx context.Context,
+	laddr string,
+	exempt http.Handler,
+	opts ...RedirectOption,
+) error {
+	cfg := newRedirectConfig(opts)
+	srv := &http.Server{
+		Handler:           newRedirectHandler(exempt, cfg),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	ln, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for HTTP: %w", err)
+	}
+	return serveUntilDone(ctx, srv, ln, cfg.ShutdownTimeout)
+}
+
+// HSTS configures the Strict-Transport-Security header sent on HTTPS responses.
+type HSTS struct {
+	// MaxAge is how long browsers remember to only use HTTPS.
+	MaxAge time.Duration
+	// IncludeSubDomains applies the policy to every subdomain.
+	IncludeSubDomains bool
+	// Preload signals consent to be included in browser preload lists.
+	Preload bool
+}
+
+// String returns the Strict-Transport-Security header value.
+func (h HSTS) String() string {
+	v := "max-age=" + strconv.FormatInt(int64(h.MaxAge/time.Second), 10)
+	if h.IncludeSubDomains {
+		v += "; includeSubDomains"
+	}
+	if h.Preload {
+		v += "; preload"
+	}
+	return v
+}
+
+func hstsHandler(h HSTS, next http.Handler) http.Handler {
+	value := h.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,187 @@
+package https_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/https"
+	"github.com/deployport/airtls/selfsigned"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	exempt := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	tests := []struct {
+		name         string
+		opts         []https.RedirectOption
+		url          string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:         "default port",
+			url:          "http://example.com/a/b?q=1&r=2",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/a/b?q=1&r=2",
+		},
+		{
+			name:         "mapped port",
+			opts:         []https.RedirectOption{https.WithRedirectPort("8443")},
+			url:          "http://example.com:8080/path",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com:8443/path",
+		},
+		{
+			name:         "IPv6 host",
+			url:          "http://[::1]:80/",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://[::1]/",
+		},
+		{
+			name:         "IPv6 host without port",
+			url:          "http://[::1]/a?b=1",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://[::1]/a?b=1",
+		},
+		{
+			name:         "IPv6 host without port to mapped port",
+			opts:         []https.RedirectOption{https.WithRedirectPort("8443")},
+			url:          "http://[::1]/a?b=1",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://[::1]:8443/a?b=1",
+		},
+		{
+			name:         "IPv6 host to mapped port",
+			opts:         []https.RedirectOption{https.WithRedirectPort("8443")},
+			url:          "http://[::1]:8080/a?b=1",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://[::1]:8443/a?b=1",
+		},
+		{
+			name:         "status code",
+			opts:         []https.RedirectOption{https.WithRedirectStatusCode(http.StatusMovedPermanently)},
+			url:          "http://example.com/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/",
+		},
+		{
+			name:       "exempt path",
+			opts:       []https.RedirectOption{https.WithRedirectExemptPaths("/healthz")},
+			url:        "http://example.com/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "exempt prefix",
+			opts:       []https.RedirectOption{https.WithRedirectExemptPaths("/.well-known/")},
+			url:        "http://example.com/.well-known/acme-challenge/token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "exact exempt path does not match children",
+			opts:         []https.RedirectOption{https.WithRedirectExemptPaths("/healthz")},
+			url:          "http://example.com/healthz/deep",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/healthz/deep",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			https.NewRedirectHandler(exempt, tt.opts...).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if location := rec.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("expected location %q, got %q", tt.wantLocation, location)
+			}
+		})
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	h := https.HSTS{MaxAge: 365 * 24 * time.Hour, IncludeSubDomains: true, Preload: true}
+	if got, want := h.String(), "max-age=31536000; includeSubDomains; preload"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestServeHTTPSWithRedirect(t *testing.T) {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithAuthorityKeyType(selfsigned.KeyTypeECDSAP256))
+	if err != nil {
+		t.Fatalf("NewAuthority failed: %v", err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	httpsAddr := freeAddr(t)
+	httpAddr := freeAddr(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan error, 1)
+	go func() {
+		served <- https.ServeHTTPS(
+			ctx,
+			selfsigned.NewCAGenerator(authority, selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)),
+			caching.NewMemoryStore(),
+			httpsAddr,
+			handler,
+			https.WithHTTPRedirect(httpAddr, https.WithRedirectExemptPaths("/healthz")),
+			https.WithHSTS(https.HSTS{MaxAge: time.Hour}),
+		)
+	}()
+	waitListening(t, httpsAddr)
+	waitListening(t, httpAddr)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: authority.CertPool()},
+			DialContext:     dialLoopback,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	httpPort := portOf(t, httpAddr)
+	httpsPort := portOf(t, httpsAddr)
+
+	resp, err := client.Get("http://localhost:" + httpPort + "/page?x=1")
+	if err != nil {
+		t.Fatalf("GET over HTTP failed: %v", err)
+	}
+	resp.Body.Close()
+	if want := "https://localhost:" + httpsPort + "/page?x=1"; resp.Header.Get("Location") != want {
+		t.Errorf("expected redirect to %s, got %d %q", want, resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	resp, err = client.Get("http://localhost:" + httpPort + "/healthz")
+	if err != nil {
+		t.Fatalf("GET exempt path failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected exempt path to be served over HTTP, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Strict-Transport-Security") != "" {
+		t.Error("expected no HSTS header over plain HTTP")
+	}
+
+	resp, err = client.Get("https://localhost:" + httpsPort + "/page")
+	if err != nil {
+		t.Fatalf("GET over HTTPS failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Strict-Transport-Security"); got != "max-age=3600" {
+		t.Errorf("expected HSTS header over HTTPS, got %q", got)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatalf("expected clean shutdown, got %v", err)
+	}
+}
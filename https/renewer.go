@@ -0,0 +1,240 @@
+package https
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	certstore "github.com/deployport/airtls/store"
+)
+
+// DefaultRenewerInterval is the default interval between renewal passes of a Renewer.
+var DefaultRenewerInterval = time.Hour
+
+// DefaultRenewerWindow is the default window before expiry in which a Renewer renews certificates.
+// It is larger than DefaultRenewalWindow so certificates are renewed before handshakes need to.
+var DefaultRenewerWindow = 45 * 24 * time.Hour
+
+// RenewerOption configures a Renewer.
+type RenewerOption func(*RenewerConfig)
+
+// RenewerConfig holds configuration for a Renewer.
+type RenewerConfig struct {
+	// Interval is the time between renewal passes.
+	Interval time.Duration
+	// RenewalWindow is how long before expiry a certificate gets renewed,
+	// capped to half of the certificate lifetime.
+	RenewalWindow time.Duration
+	// ErrorHandler is called for every certificate that fails to renew.
+	ErrorHandler func(serverName string, err error)
+	// Locker, when set, serializes renewal of a server name across processes sharing the store.
+	Locker certstore.Locker
+	// LockFallback decides what happens when Locker fails to acquire the lock.
+	LockFallback LockFallback
+	// HostPolicy, when set, decides which server names get renewed.
+	HostPolicy HostPolicy
+}
+
+// WithRenewerInterval sets the time between renewal passes.
+func WithRenewerInterval(interval time.Duration) RenewerOption {
+	return func(cfg *RenewerConfig) {
+		cfg.Interval = interval
+	}
+}
+
+// WithRenewerWindow sets how long before expiry a certificate gets renewed.
+// It should be larger than the GetCertificate renewal window so handshakes never start renewals themselves.
+func WithRenewerWindow(window time.Duration) RenewerOption {
+	return func(cfg *RenewerConfig) {
+		cfg.RenewalWindow = window
+	}
+}
+
+// WithRenewerErrorHandler sets a callback invoked for every certificate that fails to renew.
+func WithRenewerErrorHandler(handler func(serverName string, err error)) RenewerOption {
+	return func(cfg *RenewerConfig) {
+		cfg.ErrorHandler = handler
+	}
+}
+
+// WithRenewerLocker serializes renewal of a server name across processes sharing the store,
+// see WithLocker.
+func WithRenewerLocker(locker certstore.Locker, fallback LockFallback) RenewerOption {
+	return func(cfg *RenewerConfig) {
+		cfg.Locker = locker
+		cfg.LockFallback = fallback
+	}
+}
+
+// WithRenewerHostPolicy restricts which server names get renewed, usually to the policy given to WithHostPolicy.
+// Rejected names are skipped and reported to the error handler.
+func WithRenewerHostPolicy(policy HostPolicy) RenewerOption {
+	return func(cfg *RenewerConfig) {
+		cfg.HostPolicy = policy
+	}
+}
+
+// Renewer renews certificates in the background before they enter the renewal window
+// of the GetCertificate callback, so handshakes never pay the cost of generation.
+// Server names are registered with Track, usually through the WithRenewer option,
+// and every certificate listed by the store is renewed as well.
+// The Renewer only renews stored certificates, tracked names no longer in the store are dropped.
+type Renewer struct {
+	manager *certManager
+	cfg     *RenewerConfig
+	lister  certstore.CertificateLister
+
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+// NewRenewer creates a new Renewer that regenerates certificates with generator and stores them in store.
+// The store must implement store.CertificateLister.
+func NewRenewer(
+	generator certstore.Generator,
+	store certstore.Store,
+	opts ...RenewerOption,
+) (*Renewer, error) {
+	if generator == nil {
+		return nil, fmt.Errorf("generator is nil")
+	}
+	if store == nil {
+		return nil, fmt.Errorf("store is nil")
+	}
+	lister, ok := store.(certstore.CertificateLister)
+	if !ok {
+		return nil, fmt.Errorf("store %T does not list certificates", store)
+	}
+	cfg := &RenewerConfig{
+		Interval:      DefaultRenewerInterval,
+		RenewalWindow: DefaultRenewerWindow,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultRenewerInterval
+	}
+	manager := newCertManager(generator, store, cfg.RenewalWindow, renewerWindowDivisor)
+	manager.locker = cfg.Locker
+	manager.lockFallback = cfg.LockFallback
+	return &Renewer{
+		manager: manager,
+		cfg:     cfg,
+		lister:  lister,
+		names:   make(map[string]struct{}),
+	}, nil
+}
+
+// Track registers server names whose certificates should be renewed.
+func (r *Renewer) Track(serverNames ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range serverNames {
+		r.names[name] = struct{}{}
+	}
+}
+
+// RenewDue renews every tracked or stored certificate that is expired or inside the renewal window.
+// It returns the listing and renewal errors joined together,
+// names rejected by the host policy are only reported to the error handler.
+func (r *Renewer) RenewDue(ctx context.Context) error {
+	var errs []error
+	names := r.trackedNames()
+	stored, err := r.lister.ListCertificates(ctx)
+	if err != nil {
+		// tracked names are still renewed
+		errs = append(errs, fmt.Errorf("failed to list stored certificates: %w", err))
+	}
+	names = mergeNames(names, stored)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if r.cfg.HostPolicy != nil {
+			if err := r.cfg.HostPolicy(ctx, normalizeHost(name)); err != nil {
+				// not a renewal failure, the name is no longer served
+				r.untrack(name)
+				if r.cfg.ErrorHandler != nil {
+					r.cfg.ErrorHandler(name, fmt.Errorf("skipped renewal: %w", err))
+				}
+				continue
+			}
+		}
+		if err := r.renew(ctx, name); err != nil {
+			if r.cfg.ErrorHandler != nil {
+				r.cfg.ErrorHandler(name, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run renews due certificates every interval until ctx is done.
+func (r *Renewer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		// errors are reported through the error handler, a failed pass is retried on the next tick
+		_ = r.RenewDue(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Renewer) renew(ctx context.Context, name string) error {
+	cert, err := r.manager.lookup(ctx, name)
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		// deleted or evicted, the next handshake generates and tracks it again
+		r.untrack(name)
+		return nil
+	}
+	if r.manager.state(cert, time.Now()) == certValid {
+		return nil
+	}
+	_, err = r.manager.flight.do(ctx, name, func(ctx context.Context) (*tls.Certificate, error) {
+		return r.manager.refresh(ctx, name)
+	})
+	return err
+}
+
+func (r *Renewer) untrack(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.names, name)
+}
+
+func (r *Renewer) trackedNames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+	return names
+}
+
+// mergeNames appends the names of extra missing from names
+func mergeNames(names, extra []string) []string {
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		seen[name] = struct{}{}
+	}
+	for _, name := range extra {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,119 @@
+package https
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// KeyAlgorithm identifies the key type of the certificates returned by a GetCertificateFunc.
+type KeyAlgorithm int
+
+const (
+	// KeyAlgorithmUnknown makes NewSupportedGetCertificate call the getter and check the certificate it returns.
+	KeyAlgorithmUnknown KeyAlgorithm = iota
+	// KeyAlgorithmRSA is for RSA keys.
+	KeyAlgorithmRSA
+	// KeyAlgorithmECDSAP256 is for ECDSA keys on the P-256 curve.
+	KeyAlgorithmECDSAP256
+	// KeyAlgorithmECDSAP384 is for ECDSA keys on the P-384 curve.
+	KeyAlgorithmECDSAP384
+	// KeyAlgorithmEd25519 is for Ed25519 keys.
+	KeyAlgorithmEd25519
+)
+
+// SupportedGetter is a candidate of NewSupportedGetCertificate.
+type SupportedGetter struct {
+	// GetCertificate returns the certificate.
+	GetCertificate GetCertificateFunc
+	// KeyAlgorithm is the key type of the returned certificates, used to skip the getter
+	// for clients that cannot use them without generating a certificate.
+	KeyAlgorithm KeyAlgorithm
+}
+
+// NewSupportedGetCertificate combines several getters in order of preference, for example
+// one backed by an ECDSA generator followed by one backed by an RSA generator, each with its own store.
+// Getters whose key algorithm the client does not support, according to its signature schemes, curves
+// and versions, are skipped without being called. It returns the first certificate the client supports
+// according to tls.ClientHelloInfo.SupportsCertificate; a getter failing falls back to the next one.
+// If no getter succeeds, the last error is returned.
+func NewSupportedGetCertificate(getters ...SupportedGetter) (GetCertificateFunc, error) {
+	if len(getters) == 0 {
+		return nil, errors.New("no certificate getters")
+	}
+	for _, getter := range getters {
+		if getter.GetCertificate == nil {
+			return nil, errors.New("certificate getter is nil")
+		}
+	}
+	return GetCertificateFunc(func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		err := errors.New("client supports none of the certificate key algorithms")
+		for _, getter := range getters {
+			if !getter.KeyAlgorithm.supportedBy(chi) {
+				continue
+			}
+			var cert *tls.Certificate
+			cert, err = getter.GetCertificate(chi)
+			if err != nil {
+				continue
+			}
+			if err = chi.SupportsCertificate(cert); err == nil {
+				return cert, nil
+			}
+		}
+		return nil, err
+	}), nil
+}
+
+// supportedBy reports whether the client can use certificates with keys of k
+func (k KeyAlgorithm) supportedBy(chi *tls.ClientHelloInfo) bool {
+	if k == KeyAlgorithmUnknown {
+		return true
+	}
+	key, err := probeKey(k)
+	if err != nil {
+		// let the getter and the certificate check decide
+		return true
+	}
+	// the probe has no certificate to match the server name against
+	hello := *chi
+	hello.ServerName = ""
+	return hello.SupportsCertificate(&tls.Certificate{PrivateKey: key}) == nil
+}
+
+// probeKeys holds a key per algorithm, SupportsCertificate only looks at the key type without a server name
+var probeKeys sync.Map
+
+func probeKey(k KeyAlgorithm) (crypto.Signer, error) {
+	if key, ok := probeKeys.Load(k); ok {
+		return key.(crypto.Signer), nil
+	}
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch k {
+	case KeyAlgorithmRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %d", k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := probeKeys.LoadOrStore(k, key)
+	return actual.(crypto.Signer), nil
+}
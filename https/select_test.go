@@ -0,0 +1,102 @@
+package https_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/deployport/airtls/caching"
+	"github.com/deployport/airtls/https"
+	"github.com/deployport/airtls/selfsigned"
+	"github.com/deployport/airtls/store"
+)
+
+func TestNewSupportedGetCertificate(t *testing.T) {
+	ecdsaStore := caching.NewMemoryStore()
+	ecdsaGetter, err := https.NewGetCertificate(
+		selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)),
+		ecdsaStore,
+	)
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+	rsaGetter, err := https.NewGetCertificate(
+		selfsigned.NewGenerator(selfsigned.WithKeyType(selfsigned.KeyTypeRSA)),
+		caching.NewMemoryStore(),
+	)
+	if err != nil {
+		t.Fatalf("NewGetCertificate failed: %v", err)
+	}
+	getter, err := https.NewSupportedGetCertificate(
+		https.SupportedGetter{GetCertificate: ecdsaGetter, KeyAlgorithm: https.KeyAlgorithmECDSAP256},
+		https.SupportedGetter{GetCertificate: rsaGetter, KeyAlgorithm: https.KeyAlgorithmRSA},
+	)
+	if err != nil {
+		t.Fatalf("NewSupportedGetCertificate failed: %v", err)
+	}
+
+	t.Run("ECDSA capable client", func(t *testing.T) {
+		cert, err := getter(&tls.ClientHelloInfo{
+			ServerName:        "example.com",
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+			CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+		})
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if cert.Leaf.PublicKeyAlgorithm.String() != "ECDSA" {
+			t.Errorf("expected ECDSA certificate, got %s", cert.Leaf.PublicKeyAlgorithm)
+		}
+	})
+	t.Run("RSA only client", func(t *testing.T) {
+		cert, err := getter(&tls.ClientHelloInfo{
+			ServerName:        "rsa.example.com",
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256},
+			CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+		})
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		if cert.Leaf.PublicKeyAlgorithm.String() != "RSA" {
+			t.Errorf("expected RSA certificate, got %s", cert.Leaf.PublicKeyAlgorithm)
+		}
+		if _, err := ecdsaStore.StatCertificate(t.Context(), "rsa.example.com"); !store.IsCertificateNotFound(err) {
+			t.Errorf("expected no ECDSA certificate to be generated for an RSA only client, got %v", err)
+		}
+	})
+	t.Run("failing getter", func(t *testing.T) {
+		failing := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return nil, errors.New("generation failed")
+		}
+		fallback, err := https.NewSupportedGetCertificate(
+			https.SupportedGetter{GetCertificate: failing, KeyAlgorithm: https.KeyAlgorithmECDSAP256},
+			https.SupportedGetter{GetCertificate: rsaGetter, KeyAlgorithm: https.KeyAlgorithmRSA},
+		)
+		if err != nil {
+			t.Fatalf("NewSupportedGetCertificate failed: %v", err)
+		}
+		hello := &tls.ClientHelloInfo{
+			ServerName:        "example.com",
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+			CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+		}
+		cert, err := fallback(hello)
+		if err != nil {
+			t.Fatalf("expected fallback to the next getter, got %v", err)
+		}
+		if cert.Leaf.PublicKeyAlgorithm.String() != "RSA" {
+			t.Errorf("expected RSA certificate, got %s", cert.Leaf.PublicKeyAlgorithm)
+		}
+
+		allFailing, err := https.NewSupportedGetCertificate(https.SupportedGetter{GetCertificate: failing})
+		if err != nil {
+			t.Fatalf("NewSupportedGetCertificate failed: %v", err)
+		}
+		if _, err := allFailing(hello); err == nil || err.Error() != "generation failed" {
+			t.Errorf("expected the last error when every getter fails, got %v", err)
+		}
+	})
+}
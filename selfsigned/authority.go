@@ -0,0 +1,369 @@
+package selfsigned
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/deployport/airtls/store"
+)
+
+// DefaultAuthorityCommonName is the common name used for the root CA when none is configured.
+var DefaultAuthorityCommonName = "airtls Local Root CA"
+
+// DefaultAuthorityValidity is the validity period of generated CA certificates.
+var DefaultAuthorityValidity = 10 * 365 * 24 * time.Hour
+
+// DefaultLeafValidity is the validity period of leaf certificates issued by an Authority.
+var DefaultLeafValidity = 365 * 24 * time.Hour
+
+// AuthorityOption configures an Authority created by NewAuthority or LoadOrCreateAuthority.
+type AuthorityOption func(*AuthorityConfig)
+
+// AuthorityConfig holds configuration for creating an Authority.
+type AuthorityConfig struct {
+	// CommonName is the subject common name of the root CA.
+	// The intermediate, if any, uses the same name with an " Intermediate" suffix.
+	CommonName string
+	// Intermediate makes the root sign an intermediate CA that issues the leaf certificates,
+	// so the root key is only used once.
+	Intermediate bool
+	// Validity is the validity period of the CA certificates.
+	Validity time.Duration
+	// KeyType is the algorithm of the CA private keys, RSA by default.
+	KeyType KeyType
+}
+
+// WithCommonName sets the common name of the root CA.
+func WithCommonName(name string) AuthorityOption {
+	return func(cfg *AuthorityConfig) {
+		cfg.CommonName = name
+	}
+}
+
+// WithIntermediate enables issuing leaf certificates from an intermediate CA signed by the root.
+func WithIntermediate(enabled bool) AuthorityOption {
+	return func(cfg *AuthorityConfig) {
+		cfg.Intermediate = enabled
+	}
+}
+
+// WithAuthorityValidity sets the validity period of the CA certificates.
+func WithAuthorityValidity(validity time.Duration) AuthorityOption {
+	return func(cfg *AuthorityConfig) {
+		cfg.Validity = validity
+	}
+}
+
+// WithAuthorityKeyType sets the algorithm of the CA private keys.
+func WithAuthorityKeyType(keyType KeyType) AuthorityOption {
+	return func(cfg *AuthorityConfig) {
+		cfg.KeyType = keyType
+	}
+}
+
+// Authority is a local certificate authority that issues leaf certificates chained to a single root.
+// Trusting the root certificate in browsers and clients is enough to trust every issued leaf.
+type Authority struct {
+	root      *x509.Certificate
+	issuer    *x509.Certificate
+	issuerKey crypto.Signer
+	// chain holds the DER of the issuer followed by its parents, root last.
+	chain [][]byte
+}
+
+// NewAuthority creates a new Authority with a freshly generated root CA and,
+// if configured, an intermediate CA.
+func NewAuthority(opts ...AuthorityOption) (*Authority, error) {
+	cfg := &AuthorityConfig{
+		CommonName: DefaultAuthorityCommonName,
+		Validity:   DefaultAuthorityValidity,
+		KeyType:    KeyTypeRSA,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.CommonName == "" {
+		cfg.CommonName = DefaultAuthorityCommonName
+	}
+	if cfg.Validity <= 0 {
+		cfg.Validity = DefaultAuthorityValidity
+	}
+
+	now := time.Now()
+	rootKey, err := generateKey(cfg.KeyType, DefaultRSAKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate root key: %w", err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cfg.CommonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(cfg.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        !cfg.Intermediate,
+	}
+	if cfg.Intermediate {
+		rootTmpl.MaxPathLen = 1
+	}
+	root, err := createCertificate(rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create root certificate: %w", err)
+	}
+	if !cfg.Intermediate {
+		return &Authority{
+			root:      root,
+			issuer:    root,
+			issuerKey: rootKey,
+			chain:     [][]byte{root.Raw},
+		}, nil
+	}
+
+	intermediateKey, err := generateKey(cfg.KeyType, DefaultRSAKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate intermediate key: %w", err)
+	}
+	serial, err = newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	intermediateTmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cfg.CommonName + " Intermediate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              root.NotAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	intermediate, err := createCertificate(intermediateTmpl, root, intermediateKey.Public(), rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create intermediate certificate: %w", err)
+	}
+	return &Authority{
+		root:      root,
+		issuer:    intermediate,
+		issuerKey: intermediateKey,
+		chain:     [][]byte{intermediate.Raw, root.Raw},
+	}, nil
+}
+
+// LoadAuthority loads an Authority from PEM data.
+// certPEM must start with the issuing CA certificate followed by its parents up to the self-signed root,
+// each signed by the next one, and keyPEM must hold the private key of the issuing CA.
+// This is the format produced by CertificatePEM and KeyPEM.
+func LoadAuthority(certPEM, keyPEM []byte) (*Authority, error) {
+	var chain [][]byte
+	rest := certPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no CA certificate found in PEM data")
+	}
+	certs := make([]*x509.Certificate, len(chain))
+	for i, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA certificate %d: %w", i, err)
+		}
+		certs[i] = cert
+	}
+	issuer, root := certs[0], certs[len(certs)-1]
+	if !issuer.IsCA {
+		return nil, errors.New("issuer certificate is not a CA")
+	}
+	// every certificate must be signed by the next one, up to a self-signed root
+	for i := 0; i < len(certs)-1; i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return nil, fmt.Errorf("CA certificate %d is not signed by the next certificate: %w", i, err)
+		}
+	}
+	if err := root.CheckSignatureFrom(root); err != nil {
+		return nil, fmt.Errorf("last CA certificate is not a self-signed root: %w", err)
+	}
+	// tls.X509KeyPair validates that the key matches the issuer certificate.
+	pair, err := tls.X509KeyPair(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0]}), keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key pair: %w", err)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA private key is not a signer")
+	}
+	return &Authority{
+		root:      root,
+		issuer:    issuer,
+		issuerKey: signer,
+		chain:     chain,
+	}, nil
+}
+
+// LoadOrCreateAuthority loads an Authority from certFile and keyFile if both exist,
+// otherwise it creates a new one with the given options and writes it to those files.
+// The key file is written with 0600 permissions.
+func LoadOrCreateAuthority(certFile, keyFile string, opts ...AuthorityOption) (*Authority, error) {
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if certErr == nil && keyErr == nil {
+		return LoadAuthority(certPEM, keyPEM)
+	}
+	for _, err := range []error{certErr, keyErr} {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read authority: %w", err)
+		}
+	}
+	if certErr == nil || keyErr == nil {
+		return nil, fmt.Errorf("authority is incomplete, only one of %s and %s exists", certFile, keyFile)
+	}
+
+	a, err := NewAuthority(opts...)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err = a.KeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write authority key: %w", err)
+	}
+	if err := os.WriteFile(certFile, a.CertificatePEM(), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write authority certificate: %w", err)
+	}
+	return a, nil
+}
+
+// RootCertificate returns the root CA certificate that clients should trust.
+func (a *Authority) RootCertificate() *x509.Certificate {
+	return a.root
+}
+
+// RootCertificatePEM returns the PEM-encoded root CA certificate, ready to be imported into trust stores.
+func (a *Authority) RootCertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.root.Raw})
+}
+
+// CertPool returns a pool containing the root CA certificate, useful for test clients.
+func (a *Authority) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.root)
+	return pool
+}
+
+// CertificatePEM returns the PEM-encoded issuing CA certificate followed by its parents up to the root.
+func (a *Authority) CertificatePEM() []byte {
+	var buf bytes.Buffer
+	for _, der := range a.chain {
+		// writing to a bytes.Buffer never fails
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return buf.Bytes()
+}
+
+// KeyPEM returns the PKCS#8 PEM-encoded private key of the issuing CA.
+func (a *Authority) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(a.issuerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal authority key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Issue issues a leaf certificate for serverName signed by the authority.
+// The returned certificate chain contains the leaf and any intermediate, but not the root.
+func (a *Authority) Issue(serverName string, opts ...Option) (*tls.Certificate, error) {
+	return a.issue(newConfig(opts), serverName)
+}
+
+func (a *Authority) issue(cfg *Config, serverName string) (*tls.Certificate, error) {
+	priv, err := generateKey(cfg.KeyType, cfg.RSAKeySize)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(cfg.Validity)
+	if notAfter.After(a.issuer.NotAfter) {
+		notAfter = a.issuer.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: serverName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              cfg.keyUsage(),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		SignatureAlgorithm:    cfg.SignatureAlgorithm,
+	}
+	if ip := net.ParseIP(serverName); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{serverName}
+	}
+	leaf, err := createCertificate(tmpl, a.issuer, priv.Public(), a.issuerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	chain := [][]byte{leaf.Raw}
+	// the root is the last element of the issuer chain and is left out on purpose
+	chain = append(chain, a.chain[:len(a.chain)-1]...)
+	return &tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
+}
+
+// NewCAGenerator creates a certificate generator that issues leaf certificates from the given authority
+func NewCAGenerator(authority *Authority, opts ...Option) store.Generator {
+	cfg := newConfig(opts)
+	return selfSignedGeneratorFunc(func(serverName string) (*tls.Certificate, error) {
+		return authority.issue(cfg, serverName)
+	})
+}
+
+func createCertificate(tmpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
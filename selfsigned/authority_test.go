@@ -0,0 +1,126 @@
+package selfsigned_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deployport/airtls/selfsigned"
+)
+
+func TestAuthority(t *testing.T) {
+	for _, intermediate := range []bool{false, true} {
+		name := "root only"
+		if intermediate {
+			name = "with intermediate"
+		}
+		t.Run(name, func(t *testing.T) {
+			authority, err := selfsigned.NewAuthority(selfsigned.WithIntermediate(intermediate))
+			if err != nil {
+				t.Fatalf("NewAuthority failed: %v", err)
+			}
+			cert, err := selfsigned.NewCAGenerator(authority).Generate("example.com")
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			wantChain := 1
+			if intermediate {
+				wantChain = 2
+			}
+			if len(cert.Certificate) != wantChain {
+				t.Fatalf("expected chain of %d certificates, got %d", wantChain, len(cert.Certificate))
+			}
+			if cert.Leaf.IsCA {
+				t.Error("expected leaf certificate not to be a CA")
+			}
+			verifyLeaf(t, authority.CertPool(), cert.Certificate, "example.com")
+		})
+	}
+}
+
+func TestLoadOrCreateAuthority(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+
+	created, err := selfsigned.LoadOrCreateAuthority(certFile, keyFile, selfsigned.WithIntermediate(true))
+	if err != nil {
+		t.Fatalf("LoadOrCreateAuthority failed to create: %v", err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatalf("failed to stat key file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("expected key file permissions 0600, got %o", perm)
+	}
+
+	loaded, err := selfsigned.LoadOrCreateAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadOrCreateAuthority failed to load: %v", err)
+	}
+	if !loaded.RootCertificate().Equal(created.RootCertificate()) {
+		t.Fatal("expected loaded root to match created root")
+	}
+	cert, err := loaded.Issue("localhost")
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	verifyLeaf(t, created.CertPool(), cert.Certificate, "localhost")
+}
+
+func TestLoadAuthorityChain(t *testing.T) {
+	authority, err := selfsigned.NewAuthority(selfsigned.WithIntermediate(true))
+	if err != nil {
+		t.Fatalf("NewAuthority failed: %v", err)
+	}
+	keyPEM, err := authority.KeyPEM()
+	if err != nil {
+		t.Fatalf("KeyPEM failed: %v", err)
+	}
+	intermediate, root := pem.Decode(authority.CertificatePEM())
+	if _, err := selfsigned.LoadAuthority(authority.CertificatePEM(), keyPEM); err != nil {
+		t.Fatalf("LoadAuthority failed: %v", err)
+	}
+	tests := []struct {
+		name    string
+		certPEM []byte
+	}{
+		{name: "intermediate only", certPEM: pem.EncodeToMemory(intermediate)},
+		{name: "root first", certPEM: append(bytes.Clone(root), pem.EncodeToMemory(intermediate)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := selfsigned.LoadAuthority(tt.certPEM, keyPEM); err == nil {
+				t.Fatal("expected chain to be rejected")
+			}
+		})
+	}
+}
+
+func verifyLeaf(t *testing.T, roots *x509.CertPool, chain [][]byte, dnsName string) {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatalf("failed to parse leaf: %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range chain[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse intermediate: %v", err)
+		}
+		intermediates.AddCert(c)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       dnsName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		t.Fatalf("failed to verify leaf against authority root: %v", err)
+	}
+}
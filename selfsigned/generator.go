@@ -1,22 +1,23 @@
 package selfsigned
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
-	"math/big"
 	"time"
 
 	"github.com/deployport/airtls/store"
 )
 
-// NewGenerator creates a new self-signed certificate generator
-func NewGenerator() store.Generator {
-	return selfSignedGeneratorFunc(generateSelfSignedCertForHost)
+// NewGenerator creates a new self-signed certificate generator.
+// By default it generates RSA-2048 keys valid for DefaultLeafValidity.
+func NewGenerator(opts ...Option) store.Generator {
+	cfg := newConfig(opts)
+	return selfSignedGeneratorFunc(func(serverName string) (*tls.Certificate, error) {
+		return generateSelfSignedCertForHost(cfg, serverName)
+	})
 }
 
 type selfSignedGeneratorFunc func(host string) (*tls.Certificate, error)
@@ -25,31 +26,43 @@ func (f selfSignedGeneratorFunc) Generate(serverName string) (*tls.Certificate,
 	return f(serverName)
 }
 
-func generateSelfSignedCertForHost(serverName string) (*tls.Certificate, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+// GenerateContext implements store.ContextGenerator. Key generation cannot be interrupted,
+// so ctx is only checked before starting.
+func (f selfSignedGeneratorFunc) GenerateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f(serverName)
+}
+
+func generateSelfSignedCertForHost(cfg *Config, serverName string) (*tls.Certificate, error) {
+	priv, err := generateKey(cfg.KeyType, cfg.RSAKeySize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %w", err)
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
 	}
 	tmpl := x509.Certificate{
-		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		SerialNumber:          serial,
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:              time.Now().Add(cfg.Validity),
+		KeyUsage:              cfg.keyUsage(),
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 		DNSNames:              []string{serverName},
 		Subject:               pkix.Name{CommonName: serverName},
+		SignatureAlgorithm:    cfg.SignatureAlgorithm,
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &priv.PublicKey, priv)
+	leaf, err := createCertificate(&tmpl, &tmpl, priv.Public(), priv)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create certificate: %w", err)
 	}
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
-	cert, err := tls.X509KeyPair(certPEM, keyPEM)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse key pair: %w", err)
-	}
-	return &cert, nil
+	return &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
 }
@@ -0,0 +1,108 @@
+package selfsigned_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/deployport/airtls/selfsigned"
+)
+
+func TestGeneratorKeyTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []selfsigned.Option
+		check func(t *testing.T, key any)
+	}{
+		{
+			name: "RSA 3072",
+			opts: []selfsigned.Option{selfsigned.WithKeyType(selfsigned.KeyTypeRSA), selfsigned.WithRSAKeySize(3072)},
+			check: func(t *testing.T, key any) {
+				rsaKey, ok := key.(*rsa.PrivateKey)
+				if !ok {
+					t.Fatalf("expected *rsa.PrivateKey, got %T", key)
+				}
+				if rsaKey.N.BitLen() != 3072 {
+					t.Errorf("expected 3072 bit key, got %d", rsaKey.N.BitLen())
+				}
+			},
+		},
+		{
+			name: "ECDSA P-256",
+			opts: []selfsigned.Option{selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256)},
+			check: func(t *testing.T, key any) {
+				ecKey, ok := key.(*ecdsa.PrivateKey)
+				if !ok {
+					t.Fatalf("expected *ecdsa.PrivateKey, got %T", key)
+				}
+				if ecKey.Curve != elliptic.P256() {
+					t.Errorf("expected P-256 curve, got %s", ecKey.Curve.Params().Name)
+				}
+			},
+		},
+		{
+			name: "ECDSA P-384 with SHA-512",
+			opts: []selfsigned.Option{
+				selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP384),
+				selfsigned.WithSignatureAlgorithm(x509.ECDSAWithSHA512),
+			},
+			check: func(t *testing.T, key any) {
+				ecKey, ok := key.(*ecdsa.PrivateKey)
+				if !ok {
+					t.Fatalf("expected *ecdsa.PrivateKey, got %T", key)
+				}
+				if ecKey.Curve != elliptic.P384() {
+					t.Errorf("expected P-384 curve, got %s", ecKey.Curve.Params().Name)
+				}
+			},
+		},
+		{
+			name: "Ed25519",
+			opts: []selfsigned.Option{selfsigned.WithKeyType(selfsigned.KeyTypeEd25519)},
+			check: func(t *testing.T, key any) {
+				if _, ok := key.(ed25519.PrivateKey); !ok {
+					t.Fatalf("expected ed25519.PrivateKey, got %T", key)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := selfsigned.NewGenerator(tt.opts...).Generate("example.com")
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			tt.check(t, cert.PrivateKey)
+			if err := cert.Leaf.VerifyHostname("example.com"); err != nil {
+				t.Errorf("VerifyHostname failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestGeneratorValidity(t *testing.T) {
+	cert, err := selfsigned.NewGenerator(
+		selfsigned.WithKeyType(selfsigned.KeyTypeECDSAP256),
+		selfsigned.WithValidity(time.Hour),
+	).Generate("example.com")
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if remaining := time.Until(cert.Leaf.NotAfter); remaining > time.Hour {
+		t.Errorf("expected certificate to expire within an hour, got %s", remaining)
+	}
+}
+
+func TestGeneratorInvalidSignatureAlgorithm(t *testing.T) {
+	_, err := selfsigned.NewGenerator(
+		selfsigned.WithKeyType(selfsigned.KeyTypeEd25519),
+		selfsigned.WithSignatureAlgorithm(x509.SHA256WithRSA),
+	).Generate("example.com")
+	if err == nil {
+		t.Fatal("expected error for incompatible signature algorithm")
+	}
+}
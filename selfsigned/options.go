@@ -0,0 +1,141 @@
+package selfsigned
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// KeyType identifies the algorithm of generated private keys
+type KeyType int
+
+const (
+	// KeyTypeRSA generates RSA keys of Config.RSAKeySize bits
+	KeyTypeRSA KeyType = iota
+	// KeyTypeECDSAP256 generates ECDSA keys on the NIST P-256 curve
+	KeyTypeECDSAP256
+	// KeyTypeECDSAP384 generates ECDSA keys on the NIST P-384 curve
+	KeyTypeECDSAP384
+	// KeyTypeEd25519 generates Ed25519 keys
+	KeyTypeEd25519
+)
+
+// String returns the name of the key type
+func (k KeyType) String() string {
+	switch k {
+	case KeyTypeRSA:
+		return "RSA"
+	case KeyTypeECDSAP256:
+		return "ECDSA-P256"
+	case KeyTypeECDSAP384:
+		return "ECDSA-P384"
+	case KeyTypeEd25519:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("KeyType(%d)", int(k))
+	}
+}
+
+// DefaultRSAKeySize is the size in bits of generated RSA keys when none is configured.
+var DefaultRSAKeySize = 2048
+
+// Option configures the certificates created by NewGenerator and NewCAGenerator.
+type Option func(*Config)
+
+// Config holds configuration for generated certificates.
+type Config struct {
+	// KeyType is the algorithm of the generated private keys, RSA by default.
+	KeyType KeyType
+	// RSAKeySize is the size in bits of RSA keys, only used with KeyTypeRSA.
+	RSAKeySize int
+	// SignatureAlgorithm overrides the signature algorithm of the certificate.
+	// When unknown, the algorithm is derived from the signing key.
+	SignatureAlgorithm x509.SignatureAlgorithm
+	// Validity is the validity period of generated certificates.
+	Validity time.Duration
+}
+
+// WithKeyType sets the algorithm of the generated private keys.
+func WithKeyType(keyType KeyType) Option {
+	return func(cfg *Config) {
+		cfg.KeyType = keyType
+	}
+}
+
+// WithRSAKeySize sets the size in bits of generated RSA keys.
+func WithRSAKeySize(bits int) Option {
+	return func(cfg *Config) {
+		cfg.RSAKeySize = bits
+	}
+}
+
+// WithSignatureAlgorithm sets the signature algorithm of generated certificates.
+// It must be compatible with the key signing the certificate.
+func WithSignatureAlgorithm(alg x509.SignatureAlgorithm) Option {
+	return func(cfg *Config) {
+		cfg.SignatureAlgorithm = alg
+	}
+}
+
+// WithValidity sets the validity period of generated certificates.
+func WithValidity(validity time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.Validity = validity
+	}
+}
+
+func newConfig(opts []Option) *Config {
+	cfg := &Config{
+		KeyType:    KeyTypeRSA,
+		RSAKeySize: DefaultRSAKeySize,
+		Validity:   DefaultLeafValidity,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.RSAKeySize <= 0 {
+		cfg.RSAKeySize = DefaultRSAKeySize
+	}
+	if cfg.Validity <= 0 {
+		cfg.Validity = DefaultLeafValidity
+	}
+	return cfg
+}
+
+// keyUsage returns the key usage of a leaf certificate for the configured key type.
+// Key encipherment only makes sense for RSA key exchange.
+func (cfg *Config) keyUsage() x509.KeyUsage {
+	if cfg.KeyType == KeyTypeRSA {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+func generateKey(keyType KeyType, rsaKeySize int) (crypto.Signer, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch keyType {
+	case KeyTypeRSA:
+		key, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case KeyTypeECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s private key: %w", keyType, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// CertificateLeaf returns the parsed leaf certificate of cert.
+// It uses cert.Leaf when populated, otherwise it parses the first certificate of the chain.
+func CertificateLeaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert == nil {
+		return nil, errors.New("certificate is nil")
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
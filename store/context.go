@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+// ContextCertificateGetter is the context-aware variant of CertificateGetter.
+//
+// Implementations should abort the lookup and return ctx.Err() when the context is done.
+// If the certificate is not found, it returns a *CertificateNotFoundError.
+type ContextCertificateGetter interface {
+	GetCertificateContext(ctx context.Context, serverName string) (*tls.Certificate, error)
+}
+
+// ContextCertificateSetter is the context-aware variant of CertificateSetter.
+type ContextCertificateSetter interface {
+	SetCertificateContext(ctx context.Context, serverName string, cert tls.Certificate) error
+}
+
+// ContextStore is the context-aware variant of Store
+type ContextStore interface {
+	ContextCertificateGetter
+	ContextCertificateSetter
+}
+
+// ContextGenerator is the context-aware variant of Generator
+type ContextGenerator interface {
+	// GenerateContext generates a new certificate for the given server name.
+	GenerateContext(ctx context.Context, serverName string) (*tls.Certificate, error)
+}
+
+// NewContextStore adapts a Store to a ContextStore.
+// If s already implements ContextStore it is returned as is, otherwise the returned
+// store checks the context before delegating to the context-less methods.
+func NewContextStore(s Store) ContextStore {
+	if cs, ok := s.(ContextStore); ok {
+		return cs
+	}
+	return &contextStore{s: s}
+}
+
+type contextStore struct {
+	s Store
+}
+
+func (c *contextStore) GetCertificateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.s.GetCertificate(serverName)
+}
+
+func (c *contextStore) SetCertificateContext(ctx context.Context, serverName string, cert tls.Certificate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.s.SetCertificate(serverName, cert)
+}
+
+// NewContextGenerator adapts a Generator to a ContextGenerator.
+// If g already implements ContextGenerator it is returned as is, otherwise the returned
+// generator checks the context before delegating to Generate.
+func NewContextGenerator(g Generator) ContextGenerator {
+	if cg, ok := g.(ContextGenerator); ok {
+		return cg
+	}
+	return contextGenerator{g: g}
+}
+
+type contextGenerator struct {
+	g Generator
+}
+
+func (c contextGenerator) GenerateContext(ctx context.Context, serverName string) (*tls.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.g.Generate(serverName)
+}
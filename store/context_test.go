@@ -0,0 +1,56 @@
+package store_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/deployport/airtls/store"
+)
+
+type plainStore struct {
+	getCalls int
+	setCalls int
+}
+
+func (p *plainStore) GetCertificate(serverName string) (*tls.Certificate, error) {
+	p.getCalls++
+	return nil, store.NewCertificateNotFoundError()
+}
+
+func (p *plainStore) SetCertificate(serverName string, cert tls.Certificate) error {
+	p.setCalls++
+	return nil
+}
+
+func TestNewContextStore(t *testing.T) {
+	t.Run("delegates", func(t *testing.T) {
+		s := &plainStore{}
+		cs := store.NewContextStore(s)
+		if _, err := cs.GetCertificateContext(context.Background(), "example.com"); !store.IsCertificateNotFound(err) {
+			t.Fatalf("expected certificate not found, got %v", err)
+		}
+		if err := cs.SetCertificateContext(context.Background(), "example.com", tls.Certificate{}); err != nil {
+			t.Fatalf("SetCertificateContext failed: %v", err)
+		}
+		if s.getCalls != 1 || s.setCalls != 1 {
+			t.Errorf("expected one get and one set call, got %d and %d", s.getCalls, s.setCalls)
+		}
+	})
+	t.Run("cancelled context", func(t *testing.T) {
+		s := &plainStore{}
+		cs := store.NewContextStore(s)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := cs.GetCertificateContext(ctx, "example.com"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if err := cs.SetCertificateContext(ctx, "example.com", tls.Certificate{}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if s.getCalls != 0 || s.setCalls != 0 {
+			t.Errorf("expected no calls on the wrapped store, got %d gets and %d sets", s.getCalls, s.setCalls)
+		}
+	})
+}
@@ -0,0 +1,12 @@
+package store
+
+import "context"
+
+// Locker coordinates certificate generation between processes sharing a store,
+// so only one of them generates and stores a certificate for a given server name at a time.
+type Locker interface {
+	// Lock acquires the generation lock for serverName, waiting for it if another process holds it.
+	// It returns a function releasing the lock, or an error if the lock could not be acquired,
+	// for example because waiting for it timed out or ctx is done.
+	Lock(ctx context.Context, serverName string) (unlock func(), err error)
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"crypto/x509"
+	"math/big"
+	"time"
+)
+
+// CertificateDeleter implements removing certificates by server name,
+// for example to revoke a compromised certificate.
+type CertificateDeleter interface {
+	// DeleteCertificate removes the certificate of serverName. It is not an error if there is none.
+	DeleteCertificate(ctx context.Context, serverName string) error
+}
+
+// CertificateLister implements enumerating the server names held by a store.
+type CertificateLister interface {
+	// ListCertificates returns the server names with a stored certificate, in no particular order.
+	ListCertificates(ctx context.Context) ([]string, error)
+}
+
+// CertificateStater implements reading certificate metadata without loading the private key.
+type CertificateStater interface {
+	// StatCertificate returns metadata about the certificate of serverName.
+	// If the certificate is not found, it returns a *CertificateNotFoundError.
+	StatCertificate(ctx context.Context, serverName string) (*CertificateInfo, error)
+}
+
+// CertificateInfo holds metadata about a stored certificate.
+type CertificateInfo struct {
+	// ServerName is the name the certificate is stored under.
+	ServerName string
+	// Subject is the subject of the leaf certificate.
+	Subject string
+	// Issuer is the issuer of the leaf certificate.
+	Issuer string
+	// DNSNames are the DNS names the leaf certificate is valid for.
+	DNSNames []string
+	// SerialNumber is the serial number of the leaf certificate.
+	SerialNumber *big.Int
+	// NotBefore is the start of the validity period.
+	NotBefore time.Time
+	// NotAfter is the end of the validity period.
+	NotAfter time.Time
+}
+
+// NewCertificateInfo creates a CertificateInfo from the leaf certificate stored under serverName
+func NewCertificateInfo(serverName string, leaf *x509.Certificate) *CertificateInfo {
+	return &CertificateInfo{
+		ServerName:   serverName,
+		Subject:      leaf.Subject.String(),
+		Issuer:       leaf.Issuer.String(),
+		DNSNames:     leaf.DNSNames,
+		SerialNumber: leaf.SerialNumber,
+		NotBefore:    leaf.NotBefore,
+		NotAfter:     leaf.NotAfter,
+	}
+}